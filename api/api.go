@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/joe-ervin05/atomicbase/db"
 )
@@ -24,6 +29,9 @@ func Run(app *http.ServeMux) {
 	app.HandleFunc("DELETE /schema/table/{table}", handleDropTable()) // done
 	app.HandleFunc("PATCH /schema/table/{table}", handleAlterTable()) // done
 
+	app.HandleFunc("POST /schema/fts/{table}", handleCreateFts())
+	app.HandleFunc("DELETE /schema/fts/{table}", handleDropFts())
+
 	app.HandleFunc("GET /db", handleListDbs())            // done
 	app.HandleFunc("POST /db", handleCreateDb())          // done
 	app.HandleFunc("PATCH /db", handleRegisterDb())       // done
@@ -33,34 +41,237 @@ func Run(app *http.ServeMux) {
 }
 
 func handleSelectRows() http.HandlerFunc {
-	return db.WithDb(func(dao db.Database, req *http.Request) ([]byte, error) {
+	return db.WithDbStream(func(dao db.Database, req *http.Request, wr http.ResponseWriter) error {
+		params := req.URL.Query()
+
+		ranged, err := rangeParams(req.Header, params)
+		if err != nil {
+			return err
+		}
+
+		// ?download=orders.csv saves the response as a file
+		if filename := params.Get("download"); filename != "" {
+			disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+			if disposition == "" {
+				return db.ParamError{Param: "download", Offset: 0, Message: fmt.Sprintf("%s is not a valid file name", filename)}
+			}
+
+			params.Del("download")
+			wr.Header().Set("Content-Disposition", disposition)
+		}
+
+		contentType, format := streamFormat(req.Header)
+
+		// Prefer: stream=true writes large json selects as their rows are read
+		if format == "" && preference(req.Header, "stream") == "true" && !accepts(req.Header, db.ObjectContentType) {
+			contentType, format = "application/json", "json"
+		}
+
+		count := preference(req.Header, "count")
+
+		if format != "" {
+			// the headers of a stream are sent before its rows are read so they cannot say how many there are
+			if count != "" {
+				return db.ParamError{Param: "Prefer", Offset: 0, Message: "count cannot be used with streamed formats"}
+			}
+
+			if ranged {
+				return db.ParamError{Param: "Range", Offset: 0, Message: "Range cannot be used with streamed formats. Use limit and offset instead"}
+			}
+
+			wr.Header().Set("Content-Type", contentType)
+			return dao.StreamRows(req.Context(), req.PathValue("table"), params, format, wr)
+		}
+
+		data, page, err := dao.SelectRows(req.PathValue("table"), params, count)
+		if err != nil {
+			return err
+		}
+
+		if accepts(req.Header, db.ObjectContentType) {
+			data, err = db.SingleObject(data, page.Rows)
+			if err != nil {
+				return err
+			}
 
-		return dao.SelectRows(req.PathValue("table"), req.URL.Query())
+			wr.Header().Set("Content-Type", db.ObjectContentType)
+		}
+
+		wr.Header().Set("Range-Unit", "items")
+		wr.Header().Set("Content-Range", page.ContentRange())
+		if page.Cursor != "" {
+			wr.Header().Set("Next-Cursor", page.Cursor)
+		}
+		// counted selects wrap their rows with the page metadata
+		if count != "" {
+			type envelope struct {
+				Data   json.RawMessage `json:"data"`
+				Count  int             `json:"count"`
+				Cursor string          `json:"cursor,omitempty"`
+			}
+
+			data, err = json.Marshal(envelope{data, page.Total, page.Cursor})
+			if err != nil {
+				return err
+			}
+		}
+
+		if page.Partial() {
+			wr.WriteHeader(http.StatusPartialContent)
+		}
+
+		_, err = wr.Write(data)
+		return err
 	})
 }
 
+// the content type in the Accept headers with the highest quality that rows can be streamed as and its format.
+// the format is empty when json is preferred or no streamed format is accepted.
+// types with the same quality are preferred in the order they are listed.
+func streamFormat(header http.Header) (string, string) {
+	best := 0.0
+	contentType, format := "", ""
+
+	for _, accept := range header.Values("Accept") {
+		for _, media := range strings.Split(accept, ",") {
+			mediaType, mediaParams, err := mime.ParseMediaType(strings.TrimSpace(media))
+			if err != nil {
+				continue
+			}
+
+			quality := 1.0
+			if q, ok := mediaParams["q"]; ok {
+				quality, err = strconv.ParseFloat(q, 64)
+				if err != nil {
+					continue
+				}
+			}
+
+			if quality <= best {
+				continue
+			}
+
+			if mediaType == "application/json" || mediaType == "*/*" || mediaType == db.ObjectContentType {
+				best, contentType, format = quality, "", ""
+			} else if f := db.StreamFormat(mediaType); f != "" {
+				best, contentType, format = quality, mediaType, f
+			}
+		}
+	}
+
+	return contentType, format
+}
+
+// whether the Accept headers ask for a content type such as application/vnd.atomicbase.object+json
+func accepts(header http.Header, contentType string) bool {
+	for _, accept := range header.Values("Accept") {
+		for _, media := range strings.Split(accept, ",") {
+			media, _, _ = strings.Cut(strings.TrimSpace(media), ";")
+			if strings.TrimSpace(media) == contentType {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// gets the value of a preference such as "count=exact" from the Prefer headers
+func preference(header http.Header, name string) string {
+	for _, prefer := range header.Values("Prefer") {
+		for _, pref := range strings.Split(prefer, ",") {
+			key, val, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			if key == name {
+				return val
+			}
+		}
+	}
+
+	return ""
+}
+
+// turns a Range header such as "Range: 0-24" into limit and offset params and reports whether it did.
+// limit and offset params take priority over the header when both are given.
+func rangeParams(header http.Header, params url.Values) (bool, error) {
+	rng := header.Get("Range")
+	if rng == "" {
+		return false, nil
+	}
+
+	unit := header.Get("Range-Unit")
+	if unit != "" && unit != "items" {
+		return false, nil
+	}
+
+	rng = strings.TrimPrefix(rng, "items=")
+
+	first, last, found := strings.Cut(rng, "-")
+	if !found {
+		return false, db.RangeError{Range: rng}
+	}
+
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 0 {
+		return false, db.RangeError{Range: rng}
+	}
+
+	if params["offset"] == nil {
+		params.Set("offset", first)
+	}
+
+	// an open ended range such as "10-" only sets the offset
+	if last == "" || params["limit"] != nil {
+		return true, nil
+	}
+
+	end, err := strconv.Atoi(last)
+	if err != nil || end < start {
+		return false, db.RangeError{Range: rng}
+	}
+
+	params.Set("limit", strconv.Itoa(end-start+1))
+
+	return true, nil
+}
+
 func handleInsertRows() http.HandlerFunc {
-	return db.WithDb(func(dao db.Database, req *http.Request) ([]byte, error) {
-		upsert := req.Header.Get("Prefer") == "resolution=merge-duplicates"
+	return db.WithDbRes(func(dao db.Database, req *http.Request, wr http.ResponseWriter) ([]byte, error) {
+		upsert := preference(req.Header, "resolution") == "merge-duplicates"
+		single := objectResponse(req, wr)
 
-		return dao.InsertRows(req.PathValue("table"), req.URL.Query(), req.Body, upsert)
+		return dao.InsertRows(req.PathValue("table"), req.URL.Query(), req.Body, upsert, single)
 	})
 }
 
 func handleUpdateRows() http.HandlerFunc {
-	return db.WithDb(func(dao db.Database, req *http.Request) ([]byte, error) {
+	return db.WithDbRes(func(dao db.Database, req *http.Request, wr http.ResponseWriter) ([]byte, error) {
+		single := objectResponse(req, wr)
 
-		return dao.UpdateRows(req.PathValue("table"), req.URL.Query(), req.Body)
+		return dao.UpdateRows(req.PathValue("table"), req.URL.Query(), req.Body, single)
 	})
 }
 
 func handleDeleteRows() http.HandlerFunc {
-	return db.WithDb(func(dao db.Database, req *http.Request) ([]byte, error) {
+	return db.WithDbRes(func(dao db.Database, req *http.Request, wr http.ResponseWriter) ([]byte, error) {
+		single := objectResponse(req, wr)
 
-		return dao.DeleteRows(req.PathValue("table"), req.URL.Query())
+		return dao.DeleteRows(req.PathValue("table"), req.URL.Query(), single)
 	})
 }
 
+// whether an insert, update or delete should change exactly one row and respond with it as an object
+func objectResponse(req *http.Request, wr http.ResponseWriter) bool {
+	if !accepts(req.Header, db.ObjectContentType) {
+		return false
+	}
+
+	if req.URL.Query().Get("select") != "" {
+		wr.Header().Set("Content-Type", db.ObjectContentType)
+	}
+
+	return true
+}
+
 func handleCreateDb() http.HandlerFunc {
 	return db.WithPrimary(func(dao db.Database, req *http.Request) ([]byte, error) {
 
@@ -135,6 +346,20 @@ func handleAlterTable() http.HandlerFunc {
 	})
 }
 
+func handleCreateFts() http.HandlerFunc {
+	return db.WithDb(func(dao db.Database, req *http.Request) ([]byte, error) {
+		err := dao.CreateFts(req.PathValue("table"), req.Body)
+		return nil, err
+	})
+}
+
+func handleDropFts() http.HandlerFunc {
+	return db.WithDb(func(dao db.Database, req *http.Request) ([]byte, error) {
+		err := dao.DropFts(req.PathValue("table"))
+		return nil, err
+	})
+}
+
 func handlePostUdf() http.HandlerFunc {
 	return db.WithDb(func(dao db.Database, req *http.Request) ([]byte, error) {
 		return nil, nil
@@ -2,6 +2,9 @@ package api
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 )
 
@@ -29,3 +32,71 @@ func TestHandleGetRows(t *testing.T) {
 func TestHandleInsertRows(t *testing.T) {
 
 }
+
+func TestHandleSelectRowsRange(t *testing.T) {
+	err := os.MkdirAll("atomicdata", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rng := range []string{"abc", "5-1", "items=-3", "1-x"} {
+		req := httptest.NewRequest("GET", "/query/users", nil)
+		req.SetPathValue("table", "users")
+		req.Header.Set("Range", rng)
+
+		rec := httptest.NewRecorder()
+		handleSelectRows()(rec, req)
+
+		if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("expected range %s to not be satisfiable but got %d: %s", rng, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestStreamFormat(t *testing.T) {
+	cases := []struct {
+		accept string
+		format string
+	}{
+		{"text/csv", "csv"},
+		{"text/csv;q=0.1, application/json", ""},
+		{"application/json;q=0.5, text/tab-separated-values", "tsv"},
+		{"application/x-ndjson, text/csv", "ndjson"},
+		{"text/csv;q=0, application/x-ndjson;q=0.2", "ndjson"},
+		{"text/html", ""},
+	}
+
+	for _, c := range cases {
+		header := http.Header{}
+		header.Set("Accept", c.accept)
+
+		_, format := streamFormat(header)
+		if format != c.format {
+			t.Errorf("expected %s to stream as %q but got %q", c.accept, c.format, format)
+		}
+	}
+}
+
+func TestHandleSelectRowsStreamHeaders(t *testing.T) {
+	err := os.MkdirAll("atomicdata", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, header := range []http.Header{
+		{"Accept": {"text/csv"}, "Prefer": {"count=exact"}},
+		{"Accept": {"text/csv"}, "Range": {"0-9"}},
+		{"Prefer": {"stream=true, count=exact"}},
+	} {
+		req := httptest.NewRequest("GET", "/query/users", nil)
+		req.SetPathValue("table", "users")
+		req.Header = header
+
+		rec := httptest.NewRecorder()
+		handleSelectRows()(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected %v to be rejected for a streamed select but got %d: %s", header, rec.Code, rec.Body)
+		}
+	}
+}
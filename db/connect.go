@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -11,6 +12,13 @@ import (
 
 type DbHandler func(db Database, req *http.Request) ([]byte, error)
 
+// like a DbHandler but can also set the headers and status code of the response
+type DbResHandler func(db Database, req *http.Request, wr http.ResponseWriter) ([]byte, error)
+
+// like a DbResHandler but writes its response to wr as it goes rather than returning it.
+// an error can only be responded with when nothing has been written yet.
+type DbStreamHandler func(db Database, req *http.Request, wr http.ResponseWriter) error
+
 type Response struct {
 	Data  []byte      `json:"data"`
 	Error interface{} `json:"error"`
@@ -42,6 +50,14 @@ func WithPrimary(handler DbHandler) http.HandlerFunc {
 
 // for endpoints that can use either the primary or an external database
 func WithDb(handler DbHandler) http.HandlerFunc {
+	return WithDbRes(func(dao Database, req *http.Request, wr http.ResponseWriter) ([]byte, error) {
+		return handler(dao, req)
+	})
+}
+
+// for endpoints that can use either the primary or an external database
+// and need to set their own response headers or status code
+func WithDbRes(handler DbResHandler) http.HandlerFunc {
 	return func(wr http.ResponseWriter, req *http.Request) {
 		dao, err := connDb(req)
 
@@ -51,7 +67,7 @@ func WithDb(handler DbHandler) http.HandlerFunc {
 			return
 		}
 
-		data, err := handler(dao, req)
+		data, err := handler(dao, req, wr)
 		if err != nil {
 			respErr(wr, err)
 			return
@@ -64,7 +80,94 @@ func WithDb(handler DbHandler) http.HandlerFunc {
 	}
 }
 
+// for endpoints that can use either the primary or an external database
+// and write their response as they go
+func WithDbStream(handler DbStreamHandler) http.HandlerFunc {
+	return func(wr http.ResponseWriter, req *http.Request) {
+		dao, err := connDb(req)
+
+		req.Body = http.MaxBytesReader(wr, req.Body, 1048576)
+		if err != nil {
+			respErr(wr, err)
+			return
+		}
+
+		defer dao.Client.Close()
+		defer req.Body.Close()
+
+		sw := &startedWriter{wr, false}
+
+		err = handler(dao, req, sw)
+		if err == nil {
+			return
+		}
+
+		if !sw.started {
+			respErr(wr, err)
+			return
+		}
+
+		// the status was already sent so the connection is cut to keep the
+		// client from taking the rows it got for the whole response
+		log.Println(err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// a response writer that knows whether anything has been sent to the client yet
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (sw *startedWriter) WriteHeader(status int) {
+	sw.started = true
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *startedWriter) Write(data []byte) (int, error) {
+	sw.started = true
+	return sw.ResponseWriter.Write(data)
+}
+
+func (sw *startedWriter) Flush() {
+	sw.started = true
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func respErr(wr http.ResponseWriter, err error) {
+	// errors in the query params are the client's so they get a 400 that points to where they are
+	var paramErr ParamError
+	if errors.As(err, &paramErr) {
+		wr.Header().Set("Content-Type", "application/json")
+		wr.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(wr).Encode(paramErr)
+		return
+	}
+
+	// the rows could not be made into the single object the client accepts
+	var objectErr ObjectError
+	if errors.As(err, &objectErr) {
+		wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		wr.WriteHeader(http.StatusNotAcceptable)
+		wr.Write([]byte(err.Error()))
+		return
+	}
+
+	// a range that cannot be read is not satisfiable
+	var rangeErr RangeError
+	if errors.As(err, &rangeErr) {
+		wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		wr.Header().Set("Content-Range", "items */*")
+		wr.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		wr.Write([]byte(err.Error()))
+		return
+	}
+
+	// streamed responses set their content type before they run so it is replaced for the error
+	wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	wr.WriteHeader(http.StatusInternalServerError)
 	wr.Write([]byte(err.Error()))
 }
@@ -6,10 +6,14 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
 )
 
@@ -26,17 +30,26 @@ type SchemaCache struct {
 }
 
 type Fk struct {
+	// the id of the foreign key in pragma_foreign_key_list
+	Id         int
 	Table      string
 	References string
-	From       string
-	To         string
+	// the columns of the foreign key ordered by their seq
+	From []string
+	To   []string
 }
 
 type TblMap map[string]map[string]string
-type PkMap map[string]string
+
+// the primary key columns of each table in the order they are declared
+type PkMap map[string][]string
 
 func init() {
 
+	// libsql opens local files with the sqlite driver when there is one so
+	// registering it lets the local database use the functions it adds
+	sql.Register("sqlite", &sqlite3.SQLiteDriver{ConnectHook: registerFuncs})
+
 	err := os.MkdirAll("atomicdata", os.ModePerm)
 	if err != nil {
 		log.Fatal(err)
@@ -62,8 +75,8 @@ func init() {
 		"schema": "BLOB",
 	}
 
-	pks := make(map[string]string)
-	pks["databases"] = "id"
+	pks := make(PkMap)
+	pks["databases"] = []string{"id"}
 
 	var buf bytes.Buffer
 	schema := SchemaCache{tbls, pks, nil}
@@ -127,7 +140,16 @@ func QueryPrimaryInfo(db *sql.DB) (SchemaCache, error) {
 }
 
 func (dao Database) QueryMap(query string, args ...any) ([]interface{}, error) {
-	rows, err := dao.Client.Query(query, args...)
+	return queryMap(dao.Client, query, args...)
+}
+
+// anything rows can be queried from such as a database or a transaction
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func queryMap(client querier, query string, args ...any) ([]interface{}, error) {
+	rows, err := client.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -204,3 +226,69 @@ func (dao Database) QueryJSON(query string, args ...any) ([]byte, error) {
 
 	return json.Marshal(&m)
 }
+
+// compiled patterns are kept since regexp is called once for every row it filters.
+// patterns come from clients so the cache is emptied once it holds maxPatterns of them.
+const maxPatterns = 64
+
+var patterns = struct {
+	sync.Mutex
+	compiled map[string]*regexp.Regexp
+}{compiled: make(map[string]*regexp.Regexp)}
+
+// adds the functions that sqlite does not have built in to a local connection
+func registerFuncs(conn *sqlite3.SQLiteConn) error {
+	return conn.RegisterFunc("regexp", matchRegexp, true)
+}
+
+// sqlite calls regexp(pattern, value) for value REGEXP pattern
+func matchRegexp(pattern string, value any) (bool, error) {
+	if value == nil {
+		return false, nil
+	}
+
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	switch val := value.(type) {
+	case string:
+		return re.MatchString(val), nil
+	case []byte:
+		return re.Match(val), nil
+	default:
+		return re.MatchString(fmt.Sprint(val)), nil
+	}
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patterns.Lock()
+	defer patterns.Unlock()
+
+	if re, ok := patterns.compiled[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(patterns.compiled) >= maxPatterns {
+		patterns.compiled = make(map[string]*regexp.Regexp)
+	}
+
+	patterns.compiled[pattern] = re
+
+	return re, nil
+}
+
+// databases that do not have a regexp function cannot use the match and imatch operators
+func regexpErr(err error) error {
+	if err != nil && strings.Contains(err.Error(), "no such function: REGEXP") {
+		return errors.New("the match and imatch operators are not supported by this database because it does not have a regexp function")
+	}
+
+	return err
+}
@@ -101,7 +101,7 @@ import (
 // 			if err != nil {
 // 				return err
 // 			}
-// 			fks, err := schemaFks(newClient)
+// 			fks, err := schemaFks(newClient, pks)
 // 			if err != nil {
 // 				return err
 // 			}
@@ -185,7 +185,7 @@ func (dao Database) RegisterDb(body io.ReadCloser, dbToken string) error {
 	if err != nil {
 		return err
 	}
-	fks, err := schemaFks(newClient)
+	fks, err := schemaFks(newClient, pks)
 	if err != nil {
 		return err
 	}
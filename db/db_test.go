@@ -1 +1,22 @@
 package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMatchRegexpCache(t *testing.T) {
+	for i := 0; i < maxPatterns*3; i++ {
+		ok, err := matchRegexp(fmt.Sprintf("^row%d$", i), fmt.Sprintf("row%d", i))
+		if err != nil || !ok {
+			t.Fatalf("expected pattern %d to match but got %v", i, err)
+		}
+	}
+
+	patterns.Lock()
+	defer patterns.Unlock()
+
+	if len(patterns.compiled) > maxPatterns {
+		t.Errorf("expected at most %d cached patterns but got %d", maxPatterns, len(patterns.compiled))
+	}
+}
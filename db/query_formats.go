@@ -0,0 +1,203 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// the content type of a response that is the json object of a single row rather than an array
+const ObjectContentType = "application/vnd.atomicbase.object+json"
+
+// the content types that rows can be streamed as instead of one json array
+var streamFormats = map[string]string{
+	"text/csv":                  "csv",
+	"text/tab-separated-values": "tsv",
+	"application/x-ndjson":      "ndjson",
+}
+
+// the format of a content type such as text/csv or an empty string when rows can not be streamed as it
+func StreamFormat(contentType string) string {
+	return streamFormats[contentType]
+}
+
+// how many rows are written between flushes of a stream
+const flushRows = 100
+
+// writes the rows of a select to wr as they are read rather than aggregating them into one json array.
+// json writes an array of the json object of each row and ndjson writes each object on its own line.
+// each object is built by sqlite with its embedded tables already aggregated for it so nested embeds
+// are kept. csv and tsv write a header of the keys of the rows and then a line for each row with
+// embedded tables written as json in their cell. streamed rows are not counted and do not get a
+// cursor since the rows come after the headers.
+//
+// rows are flushed to the client every flushRows rows. writes to a slow client block and the next
+// row is not read until they are done so rows are only read as fast as the client takes them.
+func (dao Database) StreamRows(ctx context.Context, table string, params url.Values, format string, wr io.Writer) error {
+	if format != "json" && format != "csv" && format != "tsv" && format != "ndjson" {
+		return fmt.Errorf("rows cannot be streamed as %s", format)
+	}
+
+	sel, err := dao.buildSelect(table, params, "")
+	if err != nil {
+		return err
+	}
+
+	rows, err := dao.Client.QueryContext(ctx, fmt.Sprintf("SELECT json_object(%s) FROM (%s)", sel.agg, sel.query), sel.args...)
+	if err != nil {
+		return regexpErr(err)
+	}
+	defer rows.Close()
+
+	sw := newStreamWriter(wr)
+
+	var w *csv.Writer
+	keys := dao.Schema.outputKeys(sel.tbl)
+
+	switch format {
+	case "json":
+		_, err = sw.Write([]byte("["))
+	case "csv", "tsv":
+		w = csv.NewWriter(sw)
+		if format == "tsv" {
+			w.Comma = '\t'
+		}
+
+		err = w.Write(keys)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for i := 0; rows.Next(); i++ {
+		var obj []byte
+
+		err = rows.Scan(&obj)
+		if err != nil {
+			return err
+		}
+
+		switch format {
+		case "json":
+			if i != 0 {
+				obj = append([]byte(","), obj...)
+			}
+
+			_, err = sw.Write(obj)
+		case "ndjson":
+			_, err = sw.Write(append(obj, '\n'))
+		default:
+			var record []string
+
+			record, err = csvRecord(keys, obj)
+			if err == nil {
+				err = w.Write(record)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if (i+1)%flushRows == 0 {
+			err = sw.flush(w)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return regexpErr(err)
+	}
+
+	if format == "json" {
+		_, err = sw.Write([]byte("]"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return sw.flush(w)
+}
+
+// buffers the rows of a stream and sends them on to the client when it is flushed
+type streamWriter struct {
+	buf *bufio.Writer
+	wr  io.Writer
+}
+
+func newStreamWriter(wr io.Writer) *streamWriter {
+	return &streamWriter{bufio.NewWriterSize(wr, 32*1024), wr}
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	return sw.buf.Write(p)
+}
+
+// flushes the csv writer the rows were written with if there is one, the buffer
+// and then the response so that the client gets every row written so far
+func (sw *streamWriter) flush(w *csv.Writer) error {
+	if w != nil {
+		w.Flush()
+
+		err := w.Error()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := sw.buf.Flush()
+	if err != nil {
+		return err
+	}
+
+	if flusher, ok := sw.wr.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return nil
+}
+
+// the cells of a row for csv and tsv. strings are written as they are, null is an empty
+// cell and numbers, booleans, objects and arrays are written as json.
+func csvRecord(keys []string, obj []byte) ([]string, error) {
+	var row map[string]json.RawMessage
+
+	err := json.Unmarshal(obj, &row)
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]string, len(keys))
+
+	for i, key := range keys {
+		val := row[key]
+
+		switch {
+		case val == nil || bytes.Equal(val, []byte("null")):
+			record[i] = ""
+		case val[0] == '"':
+			var str string
+
+			err = json.Unmarshal(val, &str)
+			if err != nil {
+				return nil, err
+			}
+
+			record[i] = str
+		default:
+			record[i] = string(val)
+		}
+	}
+
+	return record, nil
+}
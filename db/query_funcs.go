@@ -1,32 +1,133 @@
 package db
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type Table struct {
-	name    string
+	name  string
+	alias string
+	// picks which foreign key to embed with when more than one links a table to its parent
+	hint string
+	// embedded with an inner join so that parents without a matching row are left out
+	inner bool
+	// the columns of a many-to-one embed are lifted into its parent rather than nested
+	spread  bool
 	columns []column
 	joins   []*Table
 	parent  *Table
+	// selected by the inner query under their alias but left out of the json
+	hidden []column
+	// the filters, order and limit of an embedded table
+	params url.Values
+	// the expressions rows are grouped by to remove duplicates when distinct is requested
+	distinct []string
 }
 
 type column struct {
-	name  string
-	alias string
+	name      string
+	alias     string
+	aggregate string
+	// the type the column is cast to such as text in price::text
+	cast string
+	// the columns, numbers and operators of a computed column such as amount*quantity
+	expr []string
+}
+
+// how an embedded table is linked to the table it is embedded in
+type relation struct {
+	parentCols []string
+	childCols  []string
+	// the parent holds the foreign key so there is at most one embedded row
+	toOne bool
+	// many-to-many relations go through a junction table with one
+	// foreign key to parentCols and another to childCols
+	junction       string
+	junctionParent []string
+	junctionChild  []string
+}
+
+// describes which slice of the matching rows a select returned
+type Page struct {
+	Offset int
+	Limit  int // -1 when no limit was requested
+	Rows   int
+	Total  int    // -1 when no count was requested
+	Cursor string // empty when there is no next page
+}
+
+// tables with more rows than this get planned counts when an estimated count is requested
+const estimatedCountThreshold = 10000
+
+// how many levels deep a table embedded in itself can be walked
+const maxEmbedDepth = 10
+
+// the last row of a page in the order it was requested.
+// it is encoded into an opaque string for clients to pass back.
+type cursor struct {
+	Keys   []string `json:"k"`
+	Values []any    `json:"v"`
+}
+
+// query parameters that shape a select rather than filter it
+var reservedParams = map[string]bool{
+	"select":   true,
+	"order":    true,
+	"or":       true,
+	"and":      true,
+	"not.or":   true,
+	"not.and":  true,
+	"limit":    true,
+	"offset":   true,
+	"cursor":   true,
+	"distinct": true,
+}
+
+// a select that is built but not yet run. query selects the rows of the page
+// and agg is the json_object entries that turn each of them into json.
+type selectQuery struct {
+	tbl     Table
+	query   string
+	args    []any
+	agg     string
+	keys    []Param
+	page    Page
+	counted chan countResult
 }
 
-func (dao Database) SelectRows(table string, params url.Values) ([]byte, error) {
+type countResult struct {
+	total int
+	err   error
+}
+
+// builds the query for the rows of a page of a select and starts counting them when a count is requested
+func (dao Database) buildSelect(table string, params url.Values, count string) (selectQuery, error) {
 	if dao.id == 1 && table == "databases" {
-		return nil, errors.New("table databases is not queryable")
+		return selectQuery{}, errors.New("table databases is not queryable")
 	}
 
 	if dao.Schema.Tables[table] == nil {
-		return nil, InvalidTblErr(table)
+		return selectQuery{}, InvalidTblErr(table)
+	}
+
+	page, err := parsePage(params)
+	if err != nil {
+		return selectQuery{}, err
+	}
+
+	if count != "" && count != "exact" && count != "planned" && count != "estimated" {
+		return selectQuery{}, ParamError{"Prefer", 0, fmt.Sprintf("count must be one of exact, planned or estimated but got %s", count)}
 	}
 
 	query := ""
@@ -43,48 +144,437 @@ func (dao Database) SelectRows(table string, params url.Values) ([]byte, error)
 		sel = "*"
 	}
 
-	sel, agg, err := dao.Schema.buildSelect(sel, table)
+	tbl, err := dao.Schema.parseSelect(sel, table)
 	if err != nil {
-		return nil, err
+		return selectQuery{}, err
+	}
+
+	params = tbl.routeParams(params)
+	tbl.params = params
+
+	err = dao.Schema.parseDistinct(&tbl, params.Get("distinct"))
+	if err != nil {
+		return selectQuery{}, err
+	}
+
+	order, err := dao.Schema.parseOrder(table, params.Get("order"))
+	if err != nil {
+		return selectQuery{}, err
+	}
+
+	// paged selects are ordered by their cursor keys so that every row has a stable position
+	var keys []Param
+	if page.Limit != -1 || params["cursor"] != nil {
+		if hasAggregate(tbl) {
+			err = ParamError{"cursor", 0, "cursors cannot be used with aggregate functions"}
+		} else if tbl.distinct != nil {
+			err = ParamError{"cursor", 0, "cursors cannot be used with distinct"}
+		} else {
+			keys, err = dao.Schema.cursorKeys(table, params)
+		}
+
+		if err != nil && params["cursor"] != nil {
+			return selectQuery{}, err
+		}
+
+		if err == nil {
+			order = keys
+			for i, key := range keys {
+				tbl.hidden = append(tbl.hidden, column{key.column, fmt.Sprintf("__cursor%d", i), "", "", nil})
+			}
+		}
+	}
+
+	sel, agg, sArgs, err := dao.Schema.buildOuterAgg(tbl)
+	if err != nil {
+		return selectQuery{}, err
 	}
 
 	query += sel
+	args = append(args, sArgs...)
 
 	where, wArgs, err := dao.Schema.buildWhere(table, params)
 	if err != nil {
-		return nil, err
+		return selectQuery{}, err
 	}
 
 	query += where
 	args = append(args, wArgs...)
 
-	query += fmt.Sprintf("GROUP BY [%s].[%s] ", table, dao.Schema.Pks[table])
+	groupBy := dao.Schema.buildGroupBy(tbl)
+	query += groupBy
+
+	counted := make(chan countResult, 1)
+
+	if count != "" {
+		// the total ignores the cursor so that it is the same for every page
+		filters := url.Values{}
+		for name, val := range params {
+			if name != "cursor" {
+				filters[name] = val
+			}
+		}
+
+		cWhere, cArgs, err := dao.Schema.buildWhere(table, filters)
+		if err != nil {
+			return selectQuery{}, err
+		}
+
+		cArgs = append(append([]any{}, sArgs...), cArgs...)
+
+		go func() {
+			total, err := dao.countRows(table, count, sel+cWhere+groupBy, cArgs)
+			counted <- countResult{total, err}
+		}()
+	} else {
+		counted <- countResult{-1, nil}
+	}
 
-	if params["order"] != nil {
-		orderBy, err := dao.Schema.buildOrder(table, params["order"][0])
+	if order != nil {
+		orderBy, oArgs, err := dao.Schema.buildOrder(order, params)
 		if err != nil {
-			return nil, err
+			return selectQuery{}, err
 		}
 
-		query += orderBy
+		query += orderBy + " "
+		args = append(args, oArgs...)
+	}
+
+	// the limit goes on the inner query so that only the requested rows get aggregated
+	if page.Limit != -1 || page.Offset != 0 {
+		query += "LIMIT ? OFFSET ? "
+		args = append(args, page.Limit, page.Offset)
+	}
+
+	return selectQuery{tbl, query, args, agg, keys, page, counted}, nil
+}
+
+func (dao Database) SelectRows(table string, params url.Values, count string) ([]byte, Page, error) {
+	sel, err := dao.buildSelect(table, params, count)
+	if err != nil {
+		return nil, Page{}, err
 	}
 
-	fmt.Printf("SELECT json_group_array(json_object(%s)) AS data FROM (%s)", agg, query)
-	fmt.Println(args)
+	page := sel.page
 
-	row := dao.Client.QueryRow(fmt.Sprintf("SELECT json_group_array(json_object(%s)) AS data FROM (%s)", agg, query), args...)
+	outer := fmt.Sprintf("json_group_array(json_object(%s)) AS data, count(*) AS rows", sel.agg)
+	if sel.tbl.hidden != nil {
+		last := ""
+		for _, col := range sel.tbl.hidden {
+			last += fmt.Sprintf("[%s], ", col.alias)
+		}
+
+		outer += fmt.Sprintf(", json_extract(json_group_array(json_array(%s)), '$[#-1]') AS cursor", last[:len(last)-2])
+	} else {
+		outer += ", NULL AS cursor"
+	}
+
+	row := dao.Client.QueryRow(fmt.Sprintf("SELECT %s FROM (%s)", outer, sel.query), sel.args...)
 	if row.Err() != nil {
-		return nil, row.Err()
+		return nil, Page{}, row.Err()
 	}
 
 	var res []byte
+	var last sql.NullString
+
+	err = row.Scan(&res, &page.Rows, &last)
+	if err != nil {
+		return nil, Page{}, regexpErr(err)
+	}
+
+	result := <-sel.counted
+	if result.err != nil {
+		return nil, Page{}, regexpErr(result.err)
+	}
+
+	page.Total = result.total
+
+	// only hand out a cursor when the page was filled since there may be more rows after it
+	if last.Valid && page.Limit != -1 && page.Rows == page.Limit {
+		page.Cursor, err = encodeCursor(sel.keys, last.String)
+	}
+
+	return res, page, err
+}
+
+// reads the limit and offset query parameters
+func parsePage(params url.Values) (Page, error) {
+	page := Page{0, -1, 0, -1, ""}
+
+	if params["limit"] != nil {
+		limit, err := strconv.Atoi(params["limit"][0])
+		if err != nil || limit < 0 {
+			return Page{}, ParamError{"limit", 0, fmt.Sprintf("limit must be a non-negative integer but got %s", params["limit"][0])}
+		}
+
+		page.Limit = limit
+	}
+
+	if params["offset"] != nil {
+		offset, err := strconv.Atoi(params["offset"][0])
+		if err != nil || offset < 0 {
+			return Page{}, ParamError{"offset", 0, fmt.Sprintf("offset must be a non-negative integer but got %s", params["offset"][0])}
+		}
+
+		page.Offset = offset
+	}
+
+	return page, nil
+}
+
+// the columns a keyset cursor is made of. this is the requested order
+// with the primary key added as a tie breaker so that every key is unique.
+func (schema SchemaCache) cursorKeys(table string, params url.Values) ([]Param, error) {
+	keys, err := schema.parseOrder(table, params.Get("order"))
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := make(map[string]bool)
+
+	for _, key := range keys {
+		if key.table != table {
+			return nil, ParamError{"cursor", 0, fmt.Sprintf("cursors can only be used when ordering by columns of table %s", table)}
+		}
+
+		if schema.Tables[table][key.column] == "" && key.column != "rowid" {
+			return nil, InvalidColErr(key.column, table)
+		}
+
+		if len(key.ops) != 0 && key.ops[0] == "rank" {
+			return nil, ParamError{"cursor", 0, "cursors cannot be used when ordering by rank"}
+		}
+
+		if orderOp(key, "collation") != "" || orderOp(key, "nulls") != "" {
+			return nil, ParamError{"cursor", 0, "cursors cannot be used with collations or nulls first and last"}
+		}
+
+		if isPath(key.column) {
+			return nil, ParamError{"cursor", 0, "cursors cannot be used when ordering by json paths"}
+		}
+
+		ordered[key.column] = true
+	}
+
+	for _, pk := range schema.pk(table) {
+		if !ordered[pk] {
+			keys = append(keys, Param{table, pk, []string{"asc"}, "", nil})
+		}
+	}
+
+	return keys, nil
+}
+
+func isDesc(key Param) bool {
+	return orderOp(key, "direction") == "desc"
+}
+
+func cursorKey(key Param) string {
+	if isDesc(key) {
+		return key.column + ".desc"
+	}
+
+	return key.column + ".asc"
+}
+
+// encodes the last row of a page which is given as a json array of its key values
+func encodeCursor(keys []Param, last string) (string, error) {
+	var values []any
+
+	err := json.Unmarshal([]byte(last), &values)
+	if err != nil {
+		return "", err
+	}
+
+	cur := cursor{nil, values}
+	for _, key := range keys {
+		cur.Keys = append(cur.Keys, cursorKey(key))
+	}
+
+	data, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(str string) (cursor, error) {
+	var cur cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return cursor{}, ParamError{"cursor", 0, "invalid cursor"}
+	}
+
+	// numbers are kept as json numbers so that large integer keys do not lose precision
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	err = dec.Decode(&cur)
+	if err != nil {
+		return cursor{}, ParamError{"cursor", 0, "invalid cursor"}
+	}
+
+	for i, val := range cur.Values {
+		if num, ok := val.(json.Number); ok {
+			if n, err := num.Int64(); err == nil {
+				cur.Values[i] = n
+			} else if n, err := num.Float64(); err == nil {
+				cur.Values[i] = n
+			}
+		}
+	}
+
+	return cur, nil
+}
+
+// builds the predicate that seeks past the row a cursor points to
+func (schema SchemaCache) buildSeek(table string, params url.Values) (string, []any, error) {
+	keys, err := schema.cursorKeys(table, params)
+	if err != nil {
+		return "", nil, err
+	}
+
+	cur, err := decodeCursor(params["cursor"][0])
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(cur.Keys) != len(keys) || len(cur.Values) != len(keys) {
+		return "", nil, ParamError{"cursor", 0, "cursor does not match the requested order"}
+	}
+
+	// when every key goes up and the cursor has no nulls a row value comparison can use an index.
+	// rows with a null key are left out of it which is right as nulls come first going up.
+	rowValue := true
+	for i, key := range keys {
+		if cur.Keys[i] != cursorKey(key) {
+			return "", nil, ParamError{"cursor", 0, "cursor does not match the requested order"}
+		}
+
+		if isDesc(key) || cur.Values[i] == nil {
+			rowValue = false
+		}
+	}
+
+	if rowValue {
+		cols := ""
+		holders := ""
+		for _, key := range keys {
+			cols += fmt.Sprintf("[%s].[%s], ", table, key.column)
+			holders += "?, "
+		}
+
+		return fmt.Sprintf("(%s) > (%s) ", cols[:len(cols)-2], holders[:len(holders)-2]), cur.Values, nil
+	}
+
+	// otherwise expand it into (a > ?) OR (a IS ? AND b < ?) OR ... where nulls come
+	// before every value going up and after every value going down like sqlite orders them
+	query := "("
+	var args []any
+
+	for i, key := range keys {
+		after := ""
+		var afterArgs []any
+
+		switch {
+		case cur.Values[i] == nil && isDesc(key):
+			// nothing comes after a null going down
+			continue
+		case cur.Values[i] == nil:
+			after = fmt.Sprintf("[%s].[%s] IS NOT NULL", table, key.column)
+		case isDesc(key):
+			after = fmt.Sprintf("([%s].[%s] < ? OR [%s].[%s] IS NULL)", table, key.column, table, key.column)
+			afterArgs = append(afterArgs, cur.Values[i])
+		default:
+			after = fmt.Sprintf("[%s].[%s] > ?", table, key.column)
+			afterArgs = append(afterArgs, cur.Values[i])
+		}
+
+		query += "("
+		for j := 0; j < i; j++ {
+			query += fmt.Sprintf("[%s].[%s] IS ? AND ", table, keys[j].column)
+			args = append(args, cur.Values[j])
+		}
+
+		query += after + ") OR "
+		args = append(args, afterArgs...)
+	}
+
+	if query == "(" {
+		return "0 ", nil, nil
+	}
+
+	return query[:len(query)-4] + ") ", args, nil
+}
+
+// counts the rows a select matches before it is limited.
+// query is the inner select without its order, limit or offset.
+func (dao Database) countRows(table, mode, query string, args []any) (int, error) {
+	if mode == "planned" || mode == "estimated" {
+		total, err := dao.statRows(table)
+		if err != nil {
+			return 0, err
+		}
+
+		// tables that have not been analyzed have no statistics so they are counted exactly
+		if total.Valid && (mode == "planned" || total.Int64 > estimatedCountThreshold) {
+			return int(total.Int64), nil
+		}
+	}
+
+	var total int
+
+	err := dao.Client.QueryRow(fmt.Sprintf("SELECT count(*) FROM (%s)", query), args...).Scan(&total)
+
+	return total, err
+}
+
+// reads the number of rows in a table from the statistics kept by ANALYZE.
+// planned counts do not take filters into account.
+func (dao Database) statRows(table string) (sql.NullInt64, error) {
+	var total sql.NullInt64
+
+	row := dao.Client.QueryRow("SELECT max(CAST(stat AS INTEGER)) FROM sqlite_stat1 WHERE tbl = ?", table)
+
+	err := row.Scan(&total)
+	if err != nil && isMissingStats(err) {
+		return total, nil
+	}
+
+	return total, err
+}
 
-	err = row.Scan(&res)
+// sqlite_stat1 only exists once ANALYZE has been run on the database
+func isMissingStats(err error) bool {
+	return strings.Contains(err.Error(), "no such table: sqlite_stat1")
+}
+
+// a page is partial when it does not cover every row that was matched
+func (page Page) Partial() bool {
+	if page.Total != -1 {
+		return page.Offset > 0 || page.Offset+page.Rows < page.Total
+	}
+
+	return page.Offset > 0 || (page.Limit != -1 && page.Rows >= page.Limit)
+}
+
+// formats the page as the value of a Content-Range header
+func (page Page) ContentRange() string {
+	total := "*"
+	if page.Total != -1 {
+		total = strconv.Itoa(page.Total)
+	}
+
+	if page.Rows == 0 {
+		return "*/" + total
+	}
 
-	return res, err
+	return fmt.Sprintf("%d-%d/%s", page.Offset, page.Offset+page.Rows-1, total)
 }
 
-func (dao Database) DeleteRows(table string, params url.Values) ([]byte, error) {
+func (dao Database) DeleteRows(table string, params url.Values, single bool) ([]byte, error) {
 
 	if dao.Schema.Tables[table] == nil {
 		return nil, InvalidTblErr(table)
@@ -109,16 +599,12 @@ func (dao Database) DeleteRows(table string, params url.Values) ([]byte, error)
 		}
 
 		query += selQuery
-
-		return dao.QueryJSON(query, args...)
 	}
 
-	_, err = dao.Client.Exec(query, args...)
-
-	return nil, err
+	return dao.mutate(query, args, params["select"] != nil, single)
 }
 
-func (dao Database) InsertRows(table string, params url.Values, body io.ReadCloser, upsert bool) ([]byte, error) {
+func (dao Database) InsertRows(table string, params url.Values, body io.ReadCloser, upsert, single bool) ([]byte, error) {
 
 	if dao.Schema.Tables[table] == nil {
 		return nil, InvalidTblErr(table)
@@ -131,7 +617,7 @@ func (dao Database) InsertRows(table string, params url.Values, body io.ReadClos
 
 	if upsert {
 		var cols []map[string]any
-		pk := dao.Schema.Pks[table]
+		pk := dao.Schema.pk(table)
 
 		err := dec.Decode(&cols)
 		if err != nil {
@@ -170,16 +656,12 @@ func (dao Database) InsertRows(table string, params url.Values, body io.ReadClos
 		}
 
 		query += selQuery
-
-		return dao.QueryJSON(query, args...)
 	}
 
-	_, err := dao.Client.Exec(query, args...)
-
-	return nil, err
+	return dao.mutate(query, args, params["select"] != nil, single)
 }
 
-func (dao Database) UpdateRows(table string, params url.Values, body io.ReadCloser) ([]byte, error) {
+func (dao Database) UpdateRows(table string, params url.Values, body io.ReadCloser, single bool) ([]byte, error) {
 
 	if dao.Schema.Tables[table] == nil {
 		return nil, InvalidTblErr(table)
@@ -227,16 +709,83 @@ func (dao Database) UpdateRows(table string, params url.Values, body io.ReadClos
 		}
 
 		query += selQuery
+	}
+
+	return dao.mutate(query, args, params["select"] != nil, single)
+}
+
+// runs an insert, update or delete and gives its rows as json when it has a RETURNING clause.
+// a single object is requested when single is set and it can only be made of one row so
+// the changes are rolled back when the query changes any other number of rows.
+func (dao Database) mutate(query string, args []any, returning, single bool) ([]byte, error) {
+	if !single && returning {
+		res, err := dao.QueryJSON(query, args...)
+		return res, regexpErr(err)
+	}
+
+	if !single {
+		_, err := dao.Client.Exec(query, args...)
+		return nil, regexpErr(err)
+	}
+
+	tx, err := dao.Client.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var res []byte
+	var rows int64
+
+	if returning {
+		m, err := queryMap(tx, query, args...)
+		if err != nil {
+			return nil, regexpErr(err)
+		}
+
+		rows = int64(len(m))
+		if rows == 1 {
+			res, err = json.Marshal(m[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return nil, regexpErr(err)
+		}
+
+		rows, err = result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		return dao.QueryJSON(query, args...)
+	if rows != 1 {
+		return nil, ObjectError{int(rows)}
 	}
 
-	_, err = dao.Client.Exec(query, args...)
+	return res, tx.Commit()
+}
+
+// turns the json array a select gives into the object of its row when it has exactly one
+func SingleObject(data []byte, rows int) ([]byte, error) {
+	if rows != 1 {
+		return nil, ObjectError{rows}
+	}
+
+	var objects []json.RawMessage
+
+	err := json.Unmarshal(data, &objects)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil, err
+	return objects[0], nil
 }
 
-func buildUpsert(colSlice []map[string]any, table string, pk string) (string, []any, error) {
+func buildUpsert(colSlice []map[string]any, table string, pk []string) (string, []any, error) {
 
 	query := "INSERT INTO [" + table + "] ( "
 	args := make([]any, len(colSlice)*len(colSlice[0]))
@@ -266,15 +815,26 @@ func buildUpsert(colSlice []map[string]any, table string, pk string) (string, []
 
 	}
 
-	query = query[:len(query)-2] + fmt.Sprintf(" ON CONFLICT(%s) DO UPDATE SET ", pk)
+	conflict := ""
+	for _, col := range pk {
+		conflict += fmt.Sprintf("[%s], ", col)
+	}
+
+	query = query[:len(query)-2] + fmt.Sprintf(" ON CONFLICT(%s) ", conflict[:len(conflict)-2])
+	set := ""
 
 	for col := range colSlice[0] {
-		if col != pk {
-			query += col + " = excluded.[" + col + "], "
+		if !slices.Contains(pk, col) {
+			set += col + " = excluded.[" + col + "], "
 		}
 	}
 
-	return query[:len(query)-2] + " ", args, nil
+	// rows made up of only their primary key have nothing to update
+	if set == "" {
+		return query + "DO NOTHING ", args, nil
+	}
+
+	return query + "DO UPDATE SET " + set[:len(set)-2] + " ", args, nil
 
 }
 
@@ -303,28 +863,25 @@ func buildInsert(cols map[string]any, table string) (string, []any, error) {
 
 }
 
-func (schema SchemaCache) buildSelect(param string, table string) (string, string, error) {
-
-	tbls, err := schema.parseSelect(param, table)
-	if err != nil {
-		return "", "", err
-	}
-
-	return schema.buildOuterAgg(tbls)
-}
-
-func (schema SchemaCache) buildOuterAgg(table Table) (string, string, error) {
+func (schema SchemaCache) buildOuterAgg(table Table) (string, string, []any, error) {
 	agg := ""
 	sel := ""
 	joins := ""
+	var args []any
 
 	if table.columns == nil && table.joins == nil {
-		table.columns = []column{{"*", ""}}
+		table.columns = []column{{"*", "", "", "", nil}}
 	}
 
-	for _, col := range table.columns {
+	err := checkAggregates(table)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	for i, col := range table.columns {
 		if col.name == "*" {
-			sel += "*, "
+			// only the columns of the table so that the columns of its joins do not shadow its embeds
+			sel += fmt.Sprintf("[%s].*, ", table.name)
 			for name := range schema.Tables[table.name] {
 				agg += fmt.Sprintf("'%s', [%s], ", name, name)
 			}
@@ -332,7 +889,31 @@ func (schema SchemaCache) buildOuterAgg(table Table) (string, string, error) {
 			continue
 		}
 
-		sel += fmt.Sprintf("[%s].[%s], ", table.name, col.name)
+		if col.aggregate != "" {
+			expr, exprArgs := aggregateExpr(table.name, col)
+			sel += fmt.Sprintf("%s AS [%s], ", expr, col.key())
+			agg += fmt.Sprintf("'%s', [%s], ", col.key(), col.key())
+			args = append(args, exprArgs...)
+			continue
+		}
+
+		if col.computed() {
+			expr, exprArgs := schema.computedExpr(table.name, col)
+			sel += fmt.Sprintf("%s AS [%s], ", expr, col.key())
+			agg += fmt.Sprintf("'%s', [%s], ", col.key(), col.key())
+			args = append(args, exprArgs...)
+			continue
+		}
+
+		if isPath(col.name) {
+			expr, exprArgs := colExpr(table.name, col.name)
+			sel += fmt.Sprintf("%s AS [%s], ", expr, pathAlias(i))
+			agg += fmt.Sprintf("'%s', %s, ", col.key(), pathValue(fmt.Sprintf("[%s]", pathAlias(i)), col.name))
+			args = append(args, exprArgs...)
+			continue
+		}
+
+		sel += fmt.Sprintf("[%s].[%s], ", table.name, col.name)
 		if col.alias != "" {
 			agg += fmt.Sprintf("'%s', [%s], ", col.alias, col.name)
 		} else {
@@ -340,191 +921,1069 @@ func (schema SchemaCache) buildOuterAgg(table Table) (string, string, error) {
 		}
 	}
 
+	for _, col := range table.hidden {
+		sel += fmt.Sprintf("[%s].[%s] AS [%s], ", table.name, col.name, col.alias)
+	}
+
 	for _, tbl := range table.joins {
-		agg += fmt.Sprintf("'%s', json([%s]), ", tbl.name, tbl.name)
-		query, aggs, err := schema.buildSelCurr(*tbl, table.name)
+		agg += schema.joinAgg(*tbl, fmt.Sprintf("[%s]", tbl.key()))
+		embed, join, jArgs, err := schema.buildEmbed(table.name, *tbl)
 		if err != nil {
-			return "", "", err
+			return "", "", nil, err
+		}
+
+		sel += embed
+		joins += join
+		args = append(args, jArgs...)
+	}
+
+	return "SELECT " + sel[:len(sel)-2] + fmt.Sprintf(" FROM [%s] ", table.name) + joins, agg[:len(agg)-2], args, nil
+}
+
+// builds the column and the join that embed a table in its parent
+func (schema SchemaCache) buildEmbed(parent string, table Table) (string, string, []any, error) {
+	rel, err := schema.findRelation(parent, table.name, table.hint)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	// embeds of a single aggregate such as comments(count) are one value that rows cannot be taken from
+	if table.scalar() {
+		for _, name := range []string{"order", "limit", "offset"} {
+			if table.params[name] != nil {
+				return "", "", nil, ParamError{table.key() + "." + name, 0, fmt.Sprintf("%s cannot be used on %s because it embeds a single aggregate. Filter its rows instead", name, table.key())}
+			}
+		}
+	}
+
+	var query, aggs string
+	var args []any
+
+	if parent == table.name && table.params["depth"] != nil {
+		query, aggs, args, err = schema.buildRecursive(table, rel)
+	} else {
+		query, aggs, args, err = schema.buildSelCurr(table, rel)
+	}
+
+	// the params of an embedded table are named after it like cars.order
+	var paramErr ParamError
+	if errors.As(err, &paramErr) {
+		paramErr.Param = table.key() + "." + paramErr.Param
+		return "", "", nil, paramErr
+	}
+
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if table.spread && !rel.toOne {
+		return "", "", nil, fmt.Errorf("%s can only be spread into %s if each row has at most one", table.name, parent)
+	}
+
+	// the rows of an embed that can have many are aggregated for each parent before they are
+	// joined so that two of them on one parent do not repeat each other's rows
+	if !rel.toOne && !table.scalar() {
+		query = manyAgg(table, query, aggs, rel)
+	}
+
+	on := ""
+	for i, name := range rel.keyNames() {
+		on += fmt.Sprintf("[%s].[%s] = [%s].[%s] AND ", parent, rel.parentCols[i], table.key(), name)
+	}
+
+	join := "LEFT JOIN"
+	if table.inner {
+		join = "JOIN"
+	}
+
+	join = fmt.Sprintf("%s (%s) AS [%s] ON %s", join, query, table.key(), on[:len(on)-4])
+
+	return embedAgg(table, aggs, rel), join, args, nil
+}
+
+// finds the foreign keys that link an embedded table to its parent. the foreign key can be
+// on the embedded table (one-to-many), on the parent (many-to-one) or the two tables can
+// both be referenced by a junction table (many-to-many). junction tables are only used
+// when there is no direct foreign key unless they are named by the hint.
+// the hint can be the column a foreign key is on or the name of a junction table.
+// tables that reference themselves embed the rows that reference each row.
+func (schema SchemaCache) findRelation(parent, child, hint string) (relation, error) {
+	var direct []relation
+	var junctions []relation
+
+	for _, key := range schema.Fks {
+		if key.References == parent && key.Table == child {
+			direct = append(direct, relation{key.To, key.From, false, "", nil, nil})
+		}
+	}
+
+	for _, key := range schema.Fks {
+		if key.Table == parent && key.References == child && parent != child {
+			direct = append(direct, relation{key.From, key.To, true, "", nil, nil})
+		}
+	}
+
+	for _, toParent := range schema.Fks {
+		if toParent.References != parent || toParent.Table == child {
+			continue
+		}
+
+		for _, toChild := range schema.Fks {
+			if toChild.Table == toParent.Table && toChild.Id != toParent.Id && toChild.References == child {
+				junctions = append(junctions, relation{toParent.To, toChild.To, false, toParent.Table, toParent.From, toChild.From})
+			}
+		}
+	}
+
+	candidates := direct
+	if hint != "" {
+		candidates = nil
+		for _, rel := range append(direct, junctions...) {
+			if rel.matches(parent, child, hint) {
+				candidates = append(candidates, rel)
+			}
+		}
+	} else if direct == nil {
+		candidates = junctions
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	if candidates == nil && hint != "" {
+		return relation{}, fmt.Errorf("no relationship between %s and %s matches the hint %s", parent, child, hint)
+	}
+
+	if candidates == nil {
+		return relation{}, fmt.Errorf("no relationship exists in the schema cache between %s and %s", parent, child)
+	}
+
+	list := ""
+	for _, rel := range candidates {
+		list += rel.describe(parent, child) + ", "
+	}
+
+	return relation{}, fmt.Errorf("more than one relationship was found between %s and %s: %s. Add a hint such as %s!column to pick one", parent, child, list[:len(list)-2], child)
+}
+
+// whether a relation uses the foreign key column or junction table named by a hint.
+// composite foreign keys can be hinted with any of their columns.
+func (rel relation) matches(parent, child, hint string) bool {
+	if rel.junction != "" {
+		return rel.junction == hint || slices.Contains(rel.junctionParent, hint) || slices.Contains(rel.junctionChild, hint)
+	}
+
+	if rel.toOne {
+		return slices.Contains(rel.parentCols, hint)
+	}
+
+	return slices.Contains(rel.childCols, hint)
+}
+
+func (rel relation) describe(parent, child string) string {
+	parentCols := strings.Join(rel.parentCols, ",")
+	childCols := strings.Join(rel.childCols, ",")
+
+	if rel.junction != "" {
+		return fmt.Sprintf("%s.%s -> %s.%s through %s", parent, parentCols, child, childCols, rel.junction)
+	}
+
+	if rel.toOne {
+		return fmt.Sprintf("%s.%s -> %s.%s", parent, parentCols, child, childCols)
+	}
+
+	return fmt.Sprintf("%s.%s -> %s.%s", child, childCols, parent, parentCols)
+}
+
+// the columns of an embedded table's subquery that its parent joins on
+func (rel relation) keyNames() []string {
+	if rel.junction == "" {
+		return rel.childCols
+	}
+
+	names := make([]string, len(rel.junctionParent))
+	for i := range names {
+		names[i] = fmt.Sprintf("__key%d", i)
+	}
+
+	return names
+}
+
+// the expressions for the join keys inside an embedded table's subquery
+func (rel relation) keyExprs(table string) []string {
+	exprs := make([]string, len(rel.childCols))
+
+	for i, col := range rel.childCols {
+		exprs[i] = fmt.Sprintf("[%s].[%s]", table, col)
+	}
+
+	if rel.junction != "" {
+		exprs = make([]string, len(rel.junctionParent))
+
+		for i, col := range rel.junctionParent {
+			exprs[i] = fmt.Sprintf("[%s].[%s]", rel.junction, col)
+		}
+	}
+
+	return exprs
+}
+
+// the primary key of a table. tables without one are keyed by their rowid
+func (schema SchemaCache) pk(table string) []string {
+	if schema.Pks[table] == nil {
+		return []string{"rowid"}
+	}
+
+	return schema.Pks[table]
+}
+
+func (schema SchemaCache) buildSelCurr(table Table, rel relation) (string, string, []any, error) {
+	var sel string
+	var joins string
+	var agg string
+	var args []any
+	selected := make(map[string]bool)
+
+	if table.columns == nil && table.joins == nil {
+		table.columns = []column{{"*", "", "", "", nil}}
+	}
+
+	err := checkAggregates(table)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	for i, col := range table.columns {
+		if col.name == "*" {
+			sel += fmt.Sprintf("[%s].*, ", table.name)
+			for name := range schema.Tables[table.name] {
+				agg += fmt.Sprintf("'%s', [%s].[%s], ", name, table.key(), name)
+				selected[name] = true
+			}
+
+			continue
+		}
+
+		if col.aggregate == "" && !col.computed() {
+			selected[col.name] = true
+		}
+
+		if col.aggregate != "" {
+			expr, exprArgs := aggregateExpr(table.name, col)
+			sel += fmt.Sprintf("%s AS [%s], ", expr, col.key())
+			agg += fmt.Sprintf("'%s', [%s].[%s], ", col.key(), table.key(), col.key())
+			args = append(args, exprArgs...)
+			continue
+		}
+
+		if col.computed() {
+			expr, exprArgs := schema.computedExpr(table.name, col)
+			sel += fmt.Sprintf("%s AS [%s], ", expr, col.key())
+			agg += fmt.Sprintf("'%s', [%s].[%s], ", col.key(), table.key(), col.key())
+			args = append(args, exprArgs...)
+			continue
+		}
+
+		if isPath(col.name) {
+			expr, exprArgs := colExpr(table.name, col.name)
+			sel += fmt.Sprintf("%s AS [%s], ", expr, pathAlias(i))
+			agg += fmt.Sprintf("'%s', %s, ", col.key(), pathValue(fmt.Sprintf("[%s].[%s]", table.key(), pathAlias(i)), col.name))
+			args = append(args, exprArgs...)
+			continue
+		}
+
+		sel += fmt.Sprintf("[%s].[%s], ", table.name, col.name)
+		if col.alias != "" {
+			agg += fmt.Sprintf("'%s', [%s].[%s], ", col.alias, table.key(), col.name)
+		} else {
+			agg += fmt.Sprintf("'%s', [%s].[%s], ", col.name, table.key(), col.name)
+		}
+	}
+
+	// the parent joins on these columns so they always have to be selected
+	keyExprs := rel.keyExprs(table.name)
+	for i, name := range rel.keyNames() {
+		if rel.junction != "" {
+			sel += fmt.Sprintf("%s AS [%s], ", keyExprs[i], name)
+		} else if !selected[name] {
+			sel += keyExprs[i] + ", "
+		}
+	}
+
+	page, err := parsePage(table.params)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	// ordered or limited embeds number their rows within each parent
+	if table.ranked() {
+		order, err := schema.parseOrder(table.name, table.params.Get("order"))
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		if order == nil {
+			for _, pk := range schema.pk(table.name) {
+				order = append(order, Param{table.name, pk, nil, "", nil})
+			}
+		}
+
+		orderBy, oArgs, err := schema.buildOrder(order, table.params)
+		if err != nil {
+			return "", "", nil, err
+		}
+		args = append(args, oArgs...)
+
+		sel += fmt.Sprintf("row_number() OVER (PARTITION BY %s %s) AS [__rank], ", strings.Join(keyExprs, ", "), orderBy)
+	}
+
+	for _, tbl := range table.joins {
+		agg += schema.joinAgg(*tbl, fmt.Sprintf("[%s].[%s]", table.key(), tbl.key()))
+		embed, join, jArgs, err := schema.buildEmbed(table.name, *tbl)
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		sel += embed
+		joins += join
+		args = append(args, jArgs...)
+	}
+
+	where, wArgs, err := schema.buildWhere(table.name, table.params)
+	if err != nil {
+		return "", "", nil, err
+	}
+	args = append(args, wArgs...)
+
+	err = schema.parseDistinct(&table, table.params.Get("distinct"))
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	groupBy := ""
+	if hasAggregate(table) || table.joins != nil || table.distinct != nil {
+		groupBy = schema.buildGroupBy(table, keyExprs...)
+	}
+
+	from := fmt.Sprintf(" FROM [%s] ", table.name)
+	if rel.junction != "" {
+		on := ""
+		for i, col := range rel.junctionChild {
+			on += fmt.Sprintf("[%s].[%s] = [%s].[%s] AND ", rel.junction, col, table.name, rel.childCols[i])
+		}
+
+		from += fmt.Sprintf("JOIN [%s] ON %s", rel.junction, on[:len(on)-4])
+	}
+
+	query, args := limitRanks("SELECT "+sel[:len(sel)-2]+from+joins+where+groupBy, page, args)
+
+	return query, agg[:len(agg)-2], args, nil
+}
+
+// keeps the rows of a ranked embed that are within its limit and offset
+func limitRanks(query string, page Page, args []any) (string, []any) {
+	if page.Limit == -1 && page.Offset == 0 {
+		return query, args
+	}
+
+	query = fmt.Sprintf("SELECT * FROM (%s) WHERE [__rank] > ? ", query)
+	args = append(args, page.Offset)
+
+	if page.Limit != -1 {
+		query += "AND [__rank] <= ? "
+		args = append(args, page.Offset+page.Limit)
+	}
+
+	return query, args
+}
+
+// builds the subquery of a table embedded in itself with a depth such as
+// replies:comments!parent_id(body)&replies.depth=3. a recursive cte walks
+// down the tree from the children of every selected row and each level is then
+// aggregated into the level above it, so every embedded row holds its own
+// replies down to the depth.
+// the filters and order of the embed apply to every level.
+func (schema SchemaCache) buildRecursive(table Table, rel relation) (string, string, []any, error) {
+	depth, err := strconv.Atoi(table.params.Get("depth"))
+	if err != nil || depth < 1 || depth > maxEmbedDepth {
+		return "", "", nil, fmt.Errorf("depth of %s must be an integer from 1 to %d", table.key(), maxEmbedDepth)
+	}
+
+	if table.joins != nil || hasAggregate(table) {
+		return "", "", nil, fmt.Errorf("recursive embed %s can only select columns", table.key())
+	}
+
+	if table.params.Get("distinct") != "" {
+		return "", "", nil, fmt.Errorf("recursive embed %s cannot be distinct", table.key())
+	}
+
+	var cols []column
+	for _, col := range table.columns {
+		if isPath(col.name) {
+			return "", "", nil, fmt.Errorf("recursive embed %s cannot select json paths", table.key())
+		}
+
+		if col.computed() {
+			return "", "", nil, fmt.Errorf("recursive embed %s cannot select casts or computed columns", table.key())
+		}
+
+		if col.name != "*" {
+			cols = append(cols, col)
+			continue
+		}
+
+		for name := range schema.Tables[table.name] {
+			cols = append(cols, column{name, "", "", "", nil})
+		}
+	}
+
+	if cols == nil {
+		for name := range schema.Tables[table.name] {
+			cols = append(cols, column{name, "", "", "", nil})
+		}
+	}
+
+	page, err := parsePage(table.params)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	order, err := schema.parseOrder(table.name, table.params.Get("order"))
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if order == nil {
+		for _, pk := range schema.pk(table.name) {
+			order = append(order, Param{table.name, pk, nil, "", nil})
+		}
+	}
+
+	for _, key := range order {
+		if len(key.ops) != 0 && key.ops[0] == "rank" || isPath(key.column) || key.embed != nil {
+			return "", "", nil, fmt.Errorf("recursive embed %s can only be ordered by its columns", table.key())
+		}
+	}
+
+	filters := url.Values{}
+	for name, val := range table.params {
+		if name != "depth" {
+			filters[name] = val
+		}
+	}
+
+	where, wArgs, err := schema.buildWhere(table.name, filters)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	// the rows of the parents that are selected can be filtered by their own params
+	parentWhere, pArgs, err := schema.buildWhere(table.parent.name, table.parent.params)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	// every child of a selected row starts a walk down the tree which is labelled by the row it started from
+	roots := ""
+	walked := ""
+	step := ""
+	childKeys := ""
+	parentKeys := ""
+	for i, col := range rel.childCols {
+		roots += fmt.Sprintf("[%s].[%s] AS [__root%d], ", table.name, col, i)
+		walked += fmt.Sprintf("[__tree].[__root%d], ", i)
+		step += fmt.Sprintf("[%s].[%s] = [__tree].[%s] AND ", table.name, col, rel.parentCols[i])
+		childKeys += fmt.Sprintf("[%s].[%s], ", table.name, col)
+		parentKeys += fmt.Sprintf("[%s].[%s], ", table.parent.name, rel.parentCols[i])
+	}
+
+	anchor := "WHERE "
+	if where != "" {
+		anchor = where + "AND "
+	}
+
+	anchor += fmt.Sprintf("(%s) IN (SELECT %s FROM [%s] %s) ", childKeys[:len(childKeys)-2], parentKeys[:len(parentKeys)-2], table.parent.name, parentWhere)
+
+	query := fmt.Sprintf("WITH RECURSIVE [__tree] AS (SELECT %s1 AS [__depth], [%s].* FROM [%s] %s", roots, table.name, table.name, anchor)
+	query += fmt.Sprintf("UNION ALL SELECT %s[__tree].[__depth] + 1, [%s].* FROM [%s] ", walked, table.name, table.name)
+	query += fmt.Sprintf("JOIN [__tree] ON %s[__tree].[__depth] < ? %s), ", step, where)
+
+	var args []any
+	args = append(args, wArgs...)
+	args = append(args, pArgs...)
+	args = append(args, depth)
+	args = append(args, wArgs...)
+
+	// the deepest level is built first so that each level can aggregate the one below it
+	for level := depth; level > 0; level-- {
+		name := fmt.Sprintf("__level%d", level)
+
+		if level == depth {
+			query += fmt.Sprintf("[%s] AS (SELECT *, NULL AS [__children] FROM [__tree] WHERE [__depth] = %d)", name, level)
+			continue
+		}
+
+		below := fmt.Sprintf("__level%d", level+1)
+		on := ""
+		group := ""
+		for i, col := range rel.childCols {
+			on += fmt.Sprintf("[%s].[__root%d] = [__tree].[__root%d] AND [%s].[%s] = [__tree].[%s] AND ", below, i, i, below, col, rel.parentCols[i])
+			group += fmt.Sprintf("[__tree].[__root%d], ", i)
+		}
+
+		for _, pk := range schema.pk(table.name) {
+			group += fmt.Sprintf("[__tree].[%s], ", pk)
+		}
+
+		children := fmt.Sprintf("json_group_array(json_object(%s) %s) FILTER (WHERE [%s].[__depth] IS NOT NULL)", recursiveObject(below, cols, table.key(), level+1 < depth), levelOrder(below, order), below)
+
+		query += fmt.Sprintf(", [%s] AS (SELECT [__tree].*, %s AS [__children] FROM [__tree] LEFT JOIN [%s] ON %s WHERE [__tree].[__depth] = %d GROUP BY %s)", name, children, below, on[:len(on)-4], level, group[:len(group)-2])
+	}
+
+	// the first level is embedded like any other table
+	sel := ""
+	agg := ""
+	for _, col := range cols {
+		sel += fmt.Sprintf("[__level1].[%s] AS [%s], ", col.name, col.key())
+		agg += fmt.Sprintf("'%s', [%s].[%s], ", col.key(), table.key(), col.key())
+	}
+
+	for _, col := range rel.childCols {
+		sel += fmt.Sprintf("[__level1].[%s] AS [%s], ", col, col)
+	}
+
+	if depth > 1 {
+		sel += fmt.Sprintf("[__level1].[__children] AS [%s], ", table.key())
+		agg += fmt.Sprintf("'%s', json([%s].[%s]), ", table.key(), table.key(), table.key())
+	}
+
+	if table.ranked() {
+		partition := ""
+		for _, col := range rel.childCols {
+			partition += fmt.Sprintf("[__level1].[%s], ", col)
+		}
+
+		sel += fmt.Sprintf("row_number() OVER (PARTITION BY %s %s) AS [__rank], ", partition[:len(partition)-2], levelOrder("__level1", order))
+	}
+
+	query += " SELECT " + sel[:len(sel)-2] + " FROM [__level1] "
+	query, args = limitRanks(query, page, args)
+
+	return query, agg[:len(agg)-2], args, nil
+}
+
+// the json object of a row in one level of a recursive embed
+func recursiveObject(level string, cols []column, key string, nested bool) string {
+	obj := ""
+	for _, col := range cols {
+		obj += fmt.Sprintf("'%s', [%s].[%s], ", col.key(), level, col.name)
+	}
+
+	if nested {
+		obj += fmt.Sprintf("'%s', json([%s].[__children]), ", key, level)
+	}
+
+	return obj[:len(obj)-2]
+}
+
+// orders the rows of one level of a recursive embed
+func levelOrder(level string, order []Param) string {
+	orderBy := "ORDER BY "
+	for _, param := range order {
+		orderBy += fmt.Sprintf("[%s].[%s] %s, ", level, param.column, orderModifiers(param))
+	}
+
+	return orderBy[:len(orderBy)-2]
+}
+
+// the entries an embedded table adds to the json object of its parent. ref is where the
+// parent query selects the embed. spread embeds add each of their keys instead of one object.
+func (schema SchemaCache) joinAgg(table Table, ref string) string {
+	if !table.spread {
+		return fmt.Sprintf("'%s', json(%s), ", table.key(), ref)
+	}
+
+	agg := ""
+	for _, key := range schema.outputKeys(table) {
+		agg += fmt.Sprintf("'%s', %s -> '$.\"%s\"', ", key, ref, key)
+	}
+
+	return agg
+}
+
+// the keys of the json object a table gives each of its rows
+func (schema SchemaCache) outputKeys(table Table) []string {
+	var keys []string
+
+	columns := table.columns
+	if columns == nil && table.joins == nil {
+		columns = []column{{"*", "", "", "", nil}}
+	}
+
+	for _, col := range columns {
+		if col.name != "*" {
+			keys = append(keys, col.key())
+			continue
 		}
-		var fk Fk
-		for _, key := range schema.Fks {
-			if key.References == table.name && key.Table == tbl.name {
-				fk = key
+
+		// sorted so that streamed formats have the same columns in the same order every time
+		var names []string
+		for name := range schema.Tables[table.name] {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		keys = append(keys, names...)
+	}
+
+	for _, tbl := range table.joins {
+		if tbl.spread {
+			keys = append(keys, schema.outputKeys(*tbl)...)
+		} else {
+			keys = append(keys, tbl.key())
+		}
+	}
+
+	return keys
+}
+
+// aggregates the rows of an embedded table into a json array for each row of its parent.
+// many-to-one embeds have at most one row so they become a single object or null instead.
+// embeds of a single aggregate such as comments(count) are grouped by their parent already
+// so they become the value of the aggregate.
+func embedAgg(table Table, aggs string, rel relation) string {
+	if table.scalar() {
+		col := table.columns[0]
+		value := fmt.Sprintf("max([%s].[%s])", table.key(), col.key())
+
+		// parents without rows have none of them rather than an unknown number
+		if col.aggregate == "count" {
+			value = fmt.Sprintf("coalesce(%s, 0)", value)
+		}
+
+		return fmt.Sprintf("%s AS [%s], ", value, table.key())
+	}
+
+	if rel.toOne {
+		return fmt.Sprintf("CASE WHEN [%s].[%s] IS NULL THEN NULL ELSE json_object(%s) END AS [%s], ", table.key(), rel.keyNames()[0], aggs, table.key())
+	}
+
+	// parents without rows are not joined to any
+	return fmt.Sprintf("coalesce([%s].[%s], json_array()) AS [%s], ", table.key(), table.key(), table.key())
+}
+
+// aggregates the rows of an embed into one json array for each parent it is joined to
+func manyAgg(table Table, query, aggs string, rel relation) string {
+	keys := ""
+	for _, name := range rel.keyNames() {
+		keys += fmt.Sprintf("[%s].[%s], ", table.key(), name)
+	}
+
+	order := ""
+	if table.ranked() {
+		order = fmt.Sprintf(" ORDER BY [%s].[__rank]", table.key())
+	}
+
+	return fmt.Sprintf("SELECT %sjson_group_array(json_object(%s)%s) AS [%s] FROM (%s) AS [%s] GROUP BY %s", keys, aggs, order, table.key(), query, table.key(), keys[:len(keys)-2])
+}
+
+// the name of an embedded table in the json output and in the query
+func (table Table) key() string {
+	if table.alias != "" {
+		return table.alias
+	}
+
+	return table.name
+}
+
+// whether an embedded table was given its own order, limit or offset
+func (table Table) ranked() bool {
+	return table.params["order"] != nil || table.params["limit"] != nil || table.params["offset"] != nil
+}
+
+// moves params that are namespaced by an embedded table such as cars.make=eq.Ford
+// or cars.tires.limit=1 onto that table and returns the params left for the top level
+func (table *Table) routeParams(params url.Values) url.Values {
+	top := url.Values{}
+
+	for name, val := range params {
+		path := splitAtomic(name, '.')
+		embed := table
+		i := 0
+
+		for ; i < len(path)-1; i++ {
+			next := embed.join(path[i])
+			if next == nil {
+				break
 			}
+			embed = next
+		}
+
+		if embed == table {
+			top[name] = val
+			continue
+		}
+
+		if embed.params == nil {
+			embed.params = url.Values{}
+		}
+
+		rest := ""
+		for _, part := range path[i:] {
+			rest += part + "."
 		}
 
-		if fk == (Fk{}) {
-			return "", "", err
+		embed.params[rest[:len(rest)-1]] = val
+	}
+
+	return top
+}
+
+// finds the embedded table with the given name or alias
+func (table *Table) join(name string) *Table {
+	for _, tbl := range table.joins {
+		if tbl.key() == name {
+			return tbl
 		}
-		sel += fmt.Sprintf("json_group_array(json_object(%s)) FILTER (WHERE [%s].[%s] IS NOT NULL) AS [%s], ", aggs, fk.Table, fk.From, tbl.name)
+	}
+
+	return nil
+}
+
+// builds the GROUP BY clause for a table in a select. tables with aggregate functions are
+// grouped by their plain columns, distinct tables by their distinct columns and every other
+// table is grouped by its primary key so that each row gets its own embedded tables. keys are
+// the expressions an embedded table is joined to its parent on which always have to be part of the groups.
+func (schema SchemaCache) buildGroupBy(table Table, keys ...string) string {
+	cols := ""
+	for _, key := range keys {
+		cols += key + ", "
+	}
+
+	if table.distinct != nil {
+		return "GROUP BY " + cols + strings.Join(table.distinct, ", ") + " "
+	}
+
+	if !hasAggregate(table) {
+		for _, pk := range schema.pk(table.name) {
+			cols += fmt.Sprintf("[%s].[%s], ", table.name, pk)
+		}
+
+		return "GROUP BY " + cols[:len(cols)-2] + " "
+	}
+
+	for i, col := range table.columns {
+		// json paths and computed columns are grouped by the name they are selected as so that their args are not bound twice
+		if col.aggregate == "" && col.computed() {
+			cols += fmt.Sprintf("[%s], ", col.key())
+		} else if col.aggregate == "" && isPath(col.name) {
+			cols += fmt.Sprintf("[%s], ", pathAlias(i))
+		} else if col.aggregate == "" {
+			cols += fmt.Sprintf("[%s].[%s], ", table.name, col.name)
+		}
+	}
+
+	// only aggregates were selected so every row is in one group
+	if cols == "" {
+		return ""
+	}
+
+	return "GROUP BY " + cols[:len(cols)-2] + " "
+}
+
+// reads the distinct param of a table. distinct=true removes rows that are the same in
+// every selected column and distinct=country,city keeps one row for each combination of
+// the listed columns with the other selected columns taken from one of its rows.
+func (schema SchemaCache) parseDistinct(table *Table, param string) error {
+	if param == "" || param == "false" {
+		return nil
+	}
+
+	if hasAggregate(*table) {
+		return ParamError{"distinct", 0, "distinct cannot be combined with aggregate functions"}
+	}
+
+	if table.joins != nil {
+		return ParamError{"distinct", 0, "distinct cannot be combined with embedded tables"}
+	}
+
+	if param == "true" {
+		columns := table.columns
+		if columns == nil {
+			columns = []column{{"*", "", "", "", nil}}
+		}
+
+		for i, col := range columns {
+			if col.name == "*" {
+				for name := range schema.Tables[table.name] {
+					table.distinct = append(table.distinct, fmt.Sprintf("[%s].[%s]", table.name, name))
+				}
+			} else if col.computed() {
+				table.distinct = append(table.distinct, fmt.Sprintf("[%s]", col.key()))
+			} else if isPath(col.name) {
+				table.distinct = append(table.distinct, fmt.Sprintf("[%s]", pathAlias(i)))
+			} else {
+				table.distinct = append(table.distinct, fmt.Sprintf("[%s].[%s]", table.name, col.name))
+			}
+		}
+
+		return nil
+	}
+
+	s := newScanner("distinct", param)
+	for {
+		s.skipSpace()
+
+		name, offset, err := s.word(", \t")
+		if err != nil {
+			return err
+		}
+
+		if name == "" {
+			return s.errorf(s.pos, "expected a column, true or false but got %s", s.describe())
+		}
+
+		if schema.Tables[table.name][name] == "" {
+			return s.errorf(offset, "%s", InvalidColErr(name, table.name))
+		}
+
+		table.distinct = append(table.distinct, fmt.Sprintf("[%s].[%s]", table.name, name))
+
+		s.skipSpace()
+		if !s.accept(',') {
+			break
+		}
+	}
+
+	if !s.done() {
+		return s.errorf(s.pos, "unexpected %s", s.describe())
+	}
+
+	return nil
+}
+
+// whether a table is an embed of a single aggregate such as comments(count) or comments(likes.sum())
+func (table Table) scalar() bool {
+	return table.parent != nil && table.joins == nil && len(table.columns) == 1 && table.columns[0].aggregate != ""
+}
+
+func hasAggregate(table Table) bool {
+	for _, col := range table.columns {
+		if col.aggregate != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// aggregates are grouped by the other columns of their table
+// which does not work with * or with embedded tables
+func checkAggregates(table Table) error {
+	if !hasAggregate(table) {
+		return nil
+	}
+
+	if table.joins != nil {
+		return fmt.Errorf("aggregate functions on %s cannot be combined with embedded tables", table.name)
+	}
+
+	for _, col := range table.columns {
+		if col.name == "*" {
+			return fmt.Errorf("aggregate functions on %s cannot be combined with *", table.name)
+		}
+	}
+
+	return nil
+}
 
-		joins += fmt.Sprintf("LEFT JOIN (%s) AS [%s] ON [%s].[%s] = [%s].[%s] ", query, tbl.name, fk.References, fk.To, fk.Table, fk.From)
+func aggregateExpr(table string, col column) (string, []any) {
+	if col.name == "" {
+		return "count(*)", nil
 	}
 
-	return "SELECT " + sel[:len(sel)-2] + fmt.Sprintf(" FROM [%s] ", table.name) + joins, agg[:len(agg)-2], nil
+	expr, args := colExpr(table, col.name)
+
+	return fmt.Sprintf("%s(%s)", mapAggregate(col.aggregate), expr), args
 }
 
-func (schema SchemaCache) buildSelCurr(table Table, joinedOn string) (string, string, error) {
-	var sel string
-	var joins string
-	var agg string
-	includesFk := false
-	var fk Fk
+// the key of a column in the json output
+func (col column) key() string {
+	if col.alias != "" {
+		return col.alias
+	}
 
-	if table.columns == nil && table.joins == nil {
-		table.columns = []column{{"*", ""}}
+	if col.aggregate != "" {
+		return col.aggregate
 	}
 
-	if joinedOn != "" {
-		for _, key := range schema.Fks {
-			if key.References == joinedOn && key.Table == table.name {
-				fk = key
-			}
-		}
+	// json paths are named after the last key in their path
+	if _, keys, _ := splitPath(col.name); keys != nil {
+		return keys[len(keys)-1]
 	}
 
-	for _, col := range table.columns {
-		if joinedOn != "" && fk.Table == table.name && fk.From == col.name {
-			includesFk = true
-		}
+	return col.name
+}
 
-		if col.name == "*" {
-			sel += "*, "
-			for name := range schema.Tables[table.name] {
-				agg += fmt.Sprintf("'%s', [%s].[%s], ", name, table.name, name)
-			}
+// casts and computed columns are selected as an expression rather than a column
+func (col column) computed() bool {
+	return col.cast != "" || col.expr != nil
+}
+
+// the expression of a cast or computed column and the args it binds.
+// numbers are bound as args and operators were checked by the parser.
+func (schema SchemaCache) computedExpr(table string, col column) (string, []any) {
+	terms := col.expr
+	if terms == nil {
+		terms = []string{col.name}
+	}
+
+	expr := ""
+	var args []any
 
+	for i, term := range terms {
+		if i%2 == 1 {
+			expr += " " + term + " "
 			continue
 		}
 
-		sel += fmt.Sprintf("[%s].[%s], ", table.name, col.name)
-		if col.alias != "" {
-			agg += fmt.Sprintf("'%s', [%s].[%s], ", col.alias, table.name, col.name)
-		} else {
-			agg += fmt.Sprintf("'%s', [%s].[%s], ", col.name, table.name, col.name)
+		if schema.Tables[table][basePath(term)] == "" {
+			expr += "?"
+			args = append(args, parseNumber(term))
+			continue
 		}
+
+		colSql, colArgs := colExpr(table, term)
+		expr += colSql
+		args = append(args, colArgs...)
 	}
 
-	if !includesFk {
-		sel += fmt.Sprintf("[%s].[%s], ", fk.Table, fk.From)
+	if col.cast != "" {
+		return fmt.Sprintf("CAST(%s AS %s)", expr, mapCast(col.cast)), args
 	}
 
-	for _, tbl := range table.joins {
-		agg += fmt.Sprintf("'%s', json([%s]), ", tbl.name, tbl.name)
-		query, aggs, err := schema.buildSelCurr(*tbl, table.name)
-		if err != nil {
-			return "", "", err
-		}
-		var fk Fk
-		for _, key := range schema.Fks {
-			if key.References == table.name && key.Table == tbl.name {
-				fk = key
-			}
-		}
-		if fk == (Fk{}) {
-			return "", "", fmt.Errorf("no relationship exists in the schema cache between %s and %s", table.name, tbl.name)
+	return "(" + expr + ")", args
+}
+
+// parses a number in a computed column keeping integers as integers
+func parseNumber(str string) any {
+	if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return n
+	}
+
+	if n, err := strconv.ParseFloat(str, 64); err == nil {
+		return n
+	}
+
+	return nil
+}
+
+// checks a selected column against the schema cache
+func (schema SchemaCache) newColumn(table, name, alias, aggregate string) (column, error) {
+	if aggregate != "" && name == "" {
+		if aggregate != "count" {
+			return column{}, fmt.Errorf("aggregate function %s requires a column", aggregate)
 		}
 
-		sel += fmt.Sprintf("json_group_array(json_object(%s)) FILTER (WHERE [%s].[%s] IS NOT NULL) AS [%s], ", aggs, fk.Table, fk.From, tbl.name)
+		return column{name, alias, aggregate, "", nil}, nil
+	}
+
+	if name == "*" && aggregate == "" {
+		return column{name, alias, aggregate, "", nil}, nil
+	}
 
-		joins += fmt.Sprintf("LEFT JOIN (%s) AS [%s] ON [%s].[%s] = [%s].[%s] ", query, tbl.name, fk.References, fk.To, fk.Table, fk.From)
+	if base, _, _ := splitPath(name); schema.Tables[table][base] == "" {
+		return column{}, InvalidColErr(base, table)
+	}
 
+	err := checkPath(name)
+	if err != nil {
+		return column{}, err
 	}
 
-	return "SELECT " + sel[:len(sel)-2] + fmt.Sprintf(" FROM [%s] ", table.name) + joins, agg[:len(agg)-2], nil
+	return column{name, alias, aggregate, "", nil}, nil
 }
 
-func (schema SchemaCache) parseSelect(param string, table string) (Table, error) {
-	tbl := Table{table, nil, nil, nil}
-	currTbl := &tbl
-	currStr := ""
-	alias := ""
-	quoted := false
-	escaped := false
+// builds the ORDER BY clause. params are the filters of the select which
+// columns ordered by their full text search rank such as description.rank take their search from
+func (schema SchemaCache) buildOrder(orderBy []Param, params url.Values) (string, []any, error) {
+	if orderBy == nil {
+		return "", nil, nil
+	}
 
-	for _, v := range param {
-		if escaped {
-			currStr += string(v)
-			escaped = false
-			continue
-		} else if v == '\\' {
-			escaped = true
-			continue
-		} else if quoted && v != '"' {
-			currStr += string(v)
-			continue
-		} else {
-			switch v {
-			case '"':
-				quoted = !quoted
-			case '(':
-				if schema.Tables[currStr] == nil {
-					return Table{}, InvalidTblErr(currStr)
-				}
-				currTbl = &Table{currStr, nil, nil, currTbl}
-				currTbl.parent.joins = append(currTbl.parent.joins, currTbl)
-				currStr = ""
-				alias = ""
-			case ')':
-				if currStr != "" {
-					if currStr != "*" && schema.Tables[currTbl.name][currStr] == "" {
-						return Table{}, InvalidColErr(currStr, currTbl.name)
-					}
-					currTbl.columns = append(currTbl.columns, column{currStr, alias})
-					currStr = ""
-				}
-				alias = ""
-				currTbl = currTbl.parent
-			case ':':
-				alias = currStr
-				currStr = ""
-			case ',':
-				if currStr != "" {
-					if currStr != "*" && schema.Tables[currTbl.name][currStr] == "" {
-						return Table{}, InvalidColErr(currStr, currTbl.name)
-					}
-					currTbl.columns = append(currTbl.columns, column{currStr, alias})
-					alias = ""
-					currStr = ""
-				}
-			default:
-				currStr += string(v)
+	query := "ORDER BY "
+	var args []any
+
+	for _, param := range orderBy {
+		ops := param.ops
+
+		if len(ops) != 0 && ops[0] == "rank" {
+			rank, rankArgs, err := schema.buildRank(param, params)
+			if err != nil {
+				return "", nil, err
 			}
-		}
-	}
 
-	if currStr != "" {
-		if currStr != "*" && schema.Tables[currTbl.name][currStr] == "" {
-			return Table{}, InvalidColErr(currStr, currTbl.name)
+			query += rank + " "
+			args = append(args, rankArgs...)
+			ops = ops[1:]
+		} else if param.embed != nil {
+			expr, exprArgs := colExpr(param.table, param.column)
+			query += fmt.Sprintf("(SELECT %s FROM [%s] WHERE %s) ", expr, param.table, embedOn(param))
+			args = append(args, exprArgs...)
+		} else {
+			expr, exprArgs := colExpr(param.table, param.column)
+			query += expr + " "
+			args = append(args, exprArgs...)
 		}
-		currTbl.columns = append(currTbl.columns, column{currStr, alias})
+
+		query += orderModifiers(param) + ", "
 	}
 
-	return tbl, nil
+	return query[:len(query)-2], args, nil
 }
 
-func (schema SchemaCache) buildOrder(table, param string) (string, error) {
-	if param == "" {
-		return "", nil
+// joins the many-to-one embed an order key is on to the table that is ordered
+func embedOn(param Param) string {
+	on := ""
+	for i, col := range param.embed.childCols {
+		on += fmt.Sprintf("[%s].[%s] = [%s].[%s] AND ", param.table, col, param.parent, param.embed.parentCols[i])
 	}
 
-	query := "ORDER BY "
+	return on[:len(on)-5]
+}
+
+// the collation, direction and nulls order that follow the expression of an order key
+func orderModifiers(param Param) string {
+	mods := ""
 
-	orderBy := splitParenthesis(param, table)
+	if collation := orderOp(param, "collation"); collation != "" {
+		mods += "COLLATE " + strings.ToUpper(collation) + " "
+	}
 
-	fmt.Println(orderBy)
+	if direction := orderOp(param, "direction"); direction != "" {
+		mods += direction + " "
+	}
 
-	for _, param := range orderBy {
-		query += fmt.Sprintf("[%s].[%s] ", param.table, param.column)
+	switch orderOp(param, "nulls") {
+	case "nullsfirst":
+		mods += "NULLS FIRST "
+	case "nullslast":
+		mods += "NULLS LAST "
+	}
 
-		if len(param.ops) != 0 && (param.ops[0] == "asc" || param.ops[0] == "desc") {
-			query += param.ops[0] + " "
-		}
+	return mods
+}
 
-		query += ", "
+// the modifier of an order key of a kind such as direction or an empty string when it has none
+func orderOp(param Param, kind string) string {
+	for _, op := range param.ops {
+		if mapOrderOp(op) == kind {
+			return op
+		}
 	}
 
-	return query[:len(query)-2], nil
+	return ""
 }
 
 func (schema SchemaCache) buildReturning(table, param string) (string, error) {
@@ -558,62 +2017,83 @@ func (schema SchemaCache) buildWhere(table string, params url.Values) (string, [
 	hasWhere := false
 	i := 0
 
-	if params["or"] != nil {
-
-		hasWhere = true
-
-		prms := splitParenthesis(params["or"][0][1:len(params["or"][0])-1], table)
+	for name, negated := range logicParams {
+		for _, val := range params[name] {
+			cond, err := schema.parseLogic(table, name, val, negated)
+			if err != nil {
+				return "", nil, err
+			}
 
-		for _, param := range prms {
-			if i != 0 {
-				query += "OR "
+			logic, logicArgs, err := schema.buildCondition(table, cond)
+			if err != nil {
+				return "", nil, err
 			}
 
-			query += fmt.Sprintf("[%s].[%s] ", param.table, param.column)
-			for _, op := range param.ops {
-				if mapOperator(op) != "" {
-					query += mapOperator(op) + " "
-				} else {
-					query += "? "
-					args = append(args, op)
-				}
+			if i != 0 {
+				query += "AND "
 			}
 
+			hasWhere = true
+			query += logic
+			args = append(args, logicArgs...)
 			i++
 		}
+	}
+
+	if params["cursor"] != nil {
+		seek, seekArgs, err := schema.buildSeek(table, params)
+		if err != nil {
+			return "", nil, err
+		}
 
-		delete(params, "or")
+		if i != 0 {
+			query += "AND "
+		}
+
+		hasWhere = true
+		query += seek
+		args = append(args, seekArgs...)
+		i++
 	}
 
 	for name, val := range params {
-		if name != "order" && name != "select" && name != "or" {
+		if !reservedParams[name] {
 			splitParam := splitAtomic(name, '.')
 			if len(splitParam) == 1 {
 				splitParam = []string{table, splitParam[0]}
 			}
 
-			if schema.Tables[splitParam[0]][splitParam[1]] == "" {
-				return "", nil, InvalidColErr(splitParam[1], splitParam[0])
+			if schema.Tables[splitParam[0]][basePath(splitParam[1])] == "" {
+				return "", nil, ParamError{name, 0, InvalidColErr(basePath(splitParam[1]), splitParam[0]).Error()}
 			}
 
-			hasWhere = true
+			err := checkPath(splitParam[1])
+			if err != nil {
+				return "", nil, ParamError{name, 0, err.Error()}
+			}
 
-			if i != 0 {
-				query += "AND "
+			if splitParam[0] != table {
+				return "", nil, ParamError{name, 0, fmt.Sprintf("cannot filter on %s.%s because %s is not embedded in the select", splitParam[0], splitParam[1], splitParam[0])}
 			}
 
-			query += fmt.Sprintf("[%s].[%s] ", splitParam[0], splitParam[1])
+			f, err := parseFilter(name, val[0])
+			if err != nil {
+				return "", nil, err
+			}
 
-			keys := splitAtomic(val[0], '.')
+			filter, filterArgs, err := schema.buildFilter(table, splitParam[1], f)
+			if err != nil {
+				return "", nil, err
+			}
 
-			for _, v := range keys {
-				if mapOperator(v) != "" {
-					query += mapOperator(v) + " "
-				} else {
-					query += "? "
-					args = append(args, v)
-				}
+			hasWhere = true
+
+			if i != 0 {
+				query += "AND "
 			}
+
+			query += filter
+			args = append(args, filterArgs...)
 			i++
 		}
 	}
@@ -625,63 +2105,327 @@ func (schema SchemaCache) buildWhere(table string, params url.Values) (string, [
 	return query, args, nil
 }
 
-type Param struct {
-	table  string
-	column string
-	ops    []string
+// builds the predicate of a filter such as age=lt.18 on a column of a table.
+// lists bind each of their values so in.(a,b,c) becomes IN (?, ?, ?) and
+// between.(1,10) becomes BETWEEN ? AND ?. negated filters are wrapped in NOT.
+func (schema SchemaCache) buildFilter(table, col string, f filter) (string, []any, error) {
+	if f.op == "fts" {
+		return schema.buildMatch(table, col, f.values[0], f.negated)
+	}
+
+	query, args := colExpr(table, col)
+
+	switch f.op {
+	case "is":
+		query += " IS " + strings.ToUpper(f.values[0]) + " "
+	case "in":
+		holders := ""
+		for _, val := range f.values {
+			holders += "?, "
+			args = append(args, pathArg(col, val))
+		}
+
+		query += fmt.Sprintf(" IN (%s) ", strings.TrimSuffix(holders, ", "))
+	case "between":
+		query += " BETWEEN ? AND ? "
+		args = append(args, pathArg(col, f.values[0]), pathArg(col, f.values[1]))
+	// LIKE follows the case_sensitive_like pragma so both sides are lowered instead
+	case "ilike":
+		query = fmt.Sprintf("lower(%s) LIKE lower(?) ", query)
+		args = append(args, f.values[0])
+	// null is distinct from every value but itself
+	case "isdistinct":
+		query += " IS NOT ? "
+		if strings.EqualFold(f.values[0], "null") {
+			args = append(args, nil)
+		} else {
+			args = append(args, pathArg(col, f.values[0]))
+		}
+	// the pattern is a go regexp so a flag makes it case insensitive
+	case "imatch":
+		query += " REGEXP ? "
+		args = append(args, "(?i)"+f.values[0])
+	default:
+		query += fmt.Sprintf(" %s ? ", mapOperator(f.op))
+		args = append(args, pathArg(col, f.values[0]))
+	}
+
+	if f.negated {
+		query = "NOT (" + query + ") "
+	}
+
+	return query, args, nil
 }
 
-func splitParenthesis(s string, table string) []Param {
-	inQuotes := false
-	var params []Param
-	param := Param{table, "", nil}
-	currStr := ""
-	escaped := false
+// the params that take a tree of filters and whether they negate it
+var logicParams = map[string]bool{
+	"or":      false,
+	"and":     false,
+	"not.or":  true,
+	"not.and": true,
+}
 
-	for _, v := range s {
-		if escaped {
-			currStr += string(v)
-			escaped = false
-		} else if v == '\\' {
-			escaped = true
-		} else if v == '"' {
-			inQuotes = !inQuotes
-		} else if v == ',' && !inQuotes {
-			if param.column == "" {
-				param.column = currStr
-			} else {
-				param.ops = append(param.ops, currStr)
+// builds the predicate of a tree of conditions wrapped in parentheses
+func (schema SchemaCache) buildCondition(table string, cond condition) (string, []any, error) {
+	var args []any
+	query := ""
+
+	if cond.logic == "" {
+		filter, filterArgs, err := schema.buildFilter(table, cond.column, cond.filter)
+		if err != nil {
+			return "", nil, err
+		}
+
+		query = "(" + filter + ") "
+		args = filterArgs
+	} else {
+		join := " AND "
+		if cond.logic == "or" {
+			join = " OR "
+		}
+
+		for i, child := range cond.conditions {
+			pred, predArgs, err := schema.buildCondition(table, child)
+			if err != nil {
+				return "", nil, err
 			}
-			params = append(params, param)
-			param = Param{}
-			currStr = ""
-		} else if v == '.' && !inQuotes {
-			if param.column == "" {
-				param.table = currStr
-			} else {
-				param.ops = append(param.ops, currStr)
+
+			if i != 0 {
+				query += join
 			}
-			currStr = ""
-		} else if v == ':' && !inQuotes {
-			param.column = currStr
-			currStr = ""
-		} else {
-			currStr += string(v)
+
+			query += pred
+			args = append(args, predArgs...)
 		}
+
+		query = "(" + query + ") "
 	}
 
-	if currStr != "" {
-		if param.column == "" {
-			param.column = currStr
+	if cond.negated {
+		query = "NOT " + query
+	}
+
+	return query, args, nil
+}
+
+// whether a column goes into its json with a path such as settings->theme
+func isPath(col string) bool {
+	return strings.Contains(col, "->")
+}
+
+// splits a column such as settings->notifications->>email into the column and the keys of
+// its json path. the last arrow picks what the path gives: -> gives the json at the path and
+// ->> gives its sql value.
+func splitPath(col string) (string, []string, bool) {
+	parts := strings.Split(col, "->")
+	text := false
+
+	for i := 1; i < len(parts); i++ {
+		text = strings.HasPrefix(parts[i], ">")
+		parts[i] = strings.TrimPrefix(parts[i], ">")
+	}
+
+	if len(parts) == 1 {
+		return col, nil, false
+	}
+
+	return parts[0], parts[1:], text
+}
+
+// checks that every key of a json path is a name or an array index such as settings->tags->0.
+// the keys are bound as args but the last one also names the column in the output.
+func checkPath(col string) error {
+	_, keys, _ := splitPath(col)
+	for _, key := range keys {
+		if key == "" {
+			return fmt.Errorf("the json path %s has an empty key", col)
+		}
+
+		if !isName(key) {
+			return fmt.Errorf("the json path %s has the key %s which can only have letters, digits and underscores", col, key)
+		}
+	}
+
+	return nil
+}
+
+// the name a json path is selected as in its subquery. it is named after the position
+// of its column rather than the path so that the path is never part of the query.
+func pathAlias(i int) string {
+	return fmt.Sprintf("__path%d", i)
+}
+
+// the column a json path goes into
+func basePath(col string) string {
+	base, _, _ := splitPath(col)
+	return base
+}
+
+// the expression for a column of a table and the args it binds.
+// json paths are bound as args rather than being put in the query.
+func colExpr(table, col string) (string, []any) {
+	base, keys, text := splitPath(col)
+	if keys == nil {
+		return fmt.Sprintf("[%s].[%s]", table, col), nil
+	}
+
+	path := "$"
+	for _, key := range keys {
+		if _, err := strconv.Atoi(key); err == nil {
+			path += "[" + key + "]"
 		} else {
-			param.ops = append(param.ops, currStr)
+			path += `."` + key + `"`
+		}
+	}
+
+	op := "->"
+	if text {
+		op = "->>"
+	}
+
+	return fmt.Sprintf("[%s].[%s] %s ?", table, base, op), []any{path}
+}
+
+// json loses its subtype when it is selected through a subquery so
+// paths that give json are read as json again in the output
+func pathValue(ref, col string) string {
+	if _, _, text := splitPath(col); text {
+		return ref
+	}
+
+	return fmt.Sprintf("json(%s)", ref)
+}
+
+// values compared with the sql value of a json path do not get the type of a column
+// so numbers and booleans are bound as the values they are in the json
+func pathArg(col, val string) any {
+	if _, keys, text := splitPath(col); keys == nil || !text {
+		return val
+	}
+
+	if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return n
+	}
+
+	if n, err := strconv.ParseFloat(val, 64); err == nil {
+		return n
+	}
+
+	switch val {
+	case "true":
+		return 1
+	case "false":
+		return 0
+	}
+
+	return val
+}
+
+// the fts5 table that indexes the text of a table
+func ftsTable(table string) string {
+	return table + "_fts"
+}
+
+// matches the rows of a table against the full text index of one of its columns
+func (schema SchemaCache) buildMatch(table, col, search string, negated bool) (string, []any, error) {
+	fts := ftsTable(table)
+
+	if _, ok := schema.Tables[fts][col]; !ok {
+		return "", nil, fmt.Errorf("%s.%s does not have a full text index", table, col)
+	}
+
+	in := "IN"
+	if negated {
+		in = "NOT IN"
+	}
+
+	return fmt.Sprintf("[%s].rowid %s (SELECT rowid FROM [%s] WHERE [%s].[%s] MATCH ?) ", table, in, fts, fts, col), []any{ftsQuery(search)}, nil
+}
+
+// the bm25 rank of each row for the full text search on a column.
+// lower ranks are better matches so ascending puts the best matches first.
+func (schema SchemaCache) buildRank(key Param, params url.Values) (string, []any, error) {
+	val := params.Get(key.column)
+	if val == "" {
+		val = params.Get(key.table + "." + key.column)
+	}
+
+	f, err := parseFilter(key.column, val)
+	if err != nil || f.op != "fts" || f.negated {
+		return "", nil, fmt.Errorf("ordering by the rank of %s.%s requires an fts filter on it", key.table, key.column)
+	}
+	search := f.values[0]
+
+	fts := ftsTable(key.table)
+
+	if _, ok := schema.Tables[fts][key.column]; !ok {
+		return "", nil, fmt.Errorf("%s.%s does not have a full text index", key.table, key.column)
+	}
+
+	rank := fmt.Sprintf("(SELECT bm25([%s]) FROM [%s] WHERE [%s].[%s] MATCH ? AND [%s].rowid = [%s].rowid)", fts, fts, fts, key.column, fts, key.table)
+
+	return rank, []any{ftsQuery(search)}, nil
+}
+
+// translates a search into an fts5 query. words are matched as they are,
+// "quoted words" are matched as a phrase, a trailing * matches a prefix and
+// OR and NOT combine terms. anything else is quoted so that it cannot be
+// read as fts5 syntax.
+func ftsQuery(search string) string {
+	query := ""
+	term := ""
+	quoted := false
+
+	addTerm := func(phrase bool) {
+		if term == "" {
+			return
+		}
+
+		if !phrase && (term == "OR" || term == "NOT" || term == "AND") {
+			query += term + " "
+			term = ""
+			return
+		}
+
+		prefix := !phrase && strings.HasSuffix(term, "*")
+		term = strings.TrimSuffix(term, "*")
+
+		if term != "" {
+			query += `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+			if prefix {
+				query += "*"
+			}
+			query += " "
 		}
+
+		term = ""
 	}
-	if param.column != "" {
-		params = append(params, param)
+
+	for _, v := range search {
+		switch {
+		case v == '"':
+			addTerm(quoted)
+			quoted = !quoted
+		case !quoted && (v == ' ' || v == '\t'):
+			addTerm(false)
+		default:
+			term += string(v)
+		}
 	}
 
-	return params
+	addTerm(quoted)
+
+	return strings.TrimSpace(query)
+}
+
+type Param struct {
+	table  string
+	column string
+	ops    []string
+	// keys such as authors(name) order the parent table by a column of its many-to-one
+	// embed which is looked up through the foreign key on the parent
+	parent string
+	embed  *relation
 }
 
 func splitAtomic(s string, delimiter rune) []string {
@@ -723,23 +2467,71 @@ func splitAtomic(s string, delimiter rune) []string {
 	return list
 }
 
+func mapAggregate(str string) string {
+
+	aggregates := map[string]string{
+		"count": "count",
+		"sum":   "sum",
+		"avg":   "avg",
+		"min":   "min",
+		"max":   "max",
+	}
+
+	return aggregates[str]
+}
+
+// the kinds of modifiers an order key can have such as name.asc.nocase.nullslast
+func mapOrderOp(str string) string {
+
+	ops := map[string]string{
+		"rank":       "rank",
+		"asc":        "direction",
+		"desc":       "direction",
+		"nullsfirst": "nulls",
+		"nullslast":  "nulls",
+		"nocase":     "collation",
+		"binary":     "collation",
+		"rtrim":      "collation",
+	}
+
+	return ops[str]
+}
+
+// the types a column can be cast to such as price::text
+func mapCast(str string) string {
+
+	casts := map[string]string{
+		"text":    "TEXT",
+		"integer": "INTEGER",
+		"int":     "INTEGER",
+		"real":    "REAL",
+		"numeric": "NUMERIC",
+		"blob":    "BLOB",
+	}
+
+	return casts[str]
+}
+
+// the arithmetic operators of computed columns such as total:amount*quantity
+const arithmeticOps = "+-*/%"
+
+// the operators that compare a column with a single value.
+// in, between, is and fts take other operands so they are parsed on their own.
 func mapOperator(str string) string {
 
 	operators := map[string]string{
-		"eq":      "=",
-		"lt":      "<",
-		"gt":      ">",
-		"lte":     "<=",
-		"gte":     ">=",
-		"neq":     "!=",
-		"like":    "LIKE",
-		"glob":    "GLOB",
-		"between": "BETWEEN",
-		"not":     "NOT",
-		"in":      "IN",
-		"is":      "IS",
-		"and":     "AND",
-		"or":      "OR",
+		"eq":         "=",
+		"lt":         "<",
+		"gt":         ">",
+		"lte":        "<=",
+		"gte":        ">=",
+		"neq":        "!=",
+		"like":       "LIKE",
+		"ilike":      "LIKE",
+		"glob":       "GLOB",
+		"match":      "REGEXP",
+		"imatch":     "REGEXP",
+		"isdistinct": "IS NOT",
 	}
 
 	return operators[str]
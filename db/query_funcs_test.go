@@ -0,0 +1,1210 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// creates a fresh set of tables with known data in the primary database
+func testDao(t *testing.T) Database {
+	dao, err := ConnPrimary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dao.Client.Exec(`
+	DROP TABLE IF EXISTS [books_fts];
+	DROP TABLE IF EXISTS [profiles];
+	DROP TABLE IF EXISTS [comments];
+	DROP TABLE IF EXISTS [ratings];
+	DROP TABLE IF EXISTS [letters];
+	DROP TABLE IF EXISTS [books_genres];
+	DROP TABLE IF EXISTS [genres];
+	DROP TABLE IF EXISTS [books];
+	DROP TABLE IF EXISTS [authors];
+	CREATE TABLE [authors] (
+		id INTEGER PRIMARY KEY,
+		name TEXT,
+		country TEXT
+	);
+	CREATE TABLE [books] (
+		id INTEGER PRIMARY KEY,
+		title TEXT,
+		pages INTEGER,
+		author_id INTEGER,
+		FOREIGN KEY(author_id) REFERENCES authors(id)
+	);
+	INSERT INTO [authors] (id, name, country) VALUES
+		(1, 'tolkien', 'uk'),
+		(2, 'herbert', 'us'),
+		(3, 'le guin', 'us'),
+		(4, 'pratchett', 'uk'),
+		(5, 'banks', 'uk');
+	INSERT INTO [books] (id, title, pages, author_id) VALUES
+		(1, 'the hobbit', 310, 1),
+		(2, 'the silmarillion', 365, 1),
+		(3, 'dune', 412, 2),
+		(4, 'the dispossessed', 387, 3),
+		(5, 'mort', 243, 4);
+	CREATE TABLE [genres] (
+		id INTEGER PRIMARY KEY,
+		name TEXT
+	);
+	CREATE TABLE [books_genres] (
+		book_id INTEGER REFERENCES books(id),
+		genre_id INTEGER REFERENCES genres(id),
+		PRIMARY KEY (book_id, genre_id)
+	);
+	INSERT INTO [genres] (id, name) VALUES (1, 'fantasy'), (2, 'science fiction'), (3, 'comedy');
+	INSERT INTO [books_genres] (book_id, genre_id) VALUES (1, 1), (2, 1), (3, 2), (4, 2), (5, 1), (5, 3);
+	CREATE TABLE [letters] (
+		id INTEGER PRIMARY KEY,
+		body TEXT,
+		sender_id INTEGER REFERENCES authors(id),
+		recipient_id INTEGER REFERENCES authors(id)
+	);
+	INSERT INTO [letters] (id, body, sender_id, recipient_id) VALUES (1, 'hello', 1, 4), (2, 'hi', 4, 1), (3, 'hey', 2, 1);
+	CREATE TABLE [ratings] (
+		id INTEGER PRIMARY KEY,
+		book_id INTEGER,
+		genre_id INTEGER,
+		score INTEGER,
+		FOREIGN KEY(book_id, genre_id) REFERENCES books_genres(book_id, genre_id)
+	);
+	INSERT INTO [ratings] (id, book_id, genre_id, score) VALUES (1, 5, 1, 3), (2, 5, 3, 5), (3, 5, 3, 4);
+	CREATE TABLE [comments] (
+		id INTEGER PRIMARY KEY,
+		body TEXT,
+		parent_id INTEGER REFERENCES comments(id)
+	);
+	INSERT INTO [comments] (id, body, parent_id) VALUES
+		(1, 'first', NULL),
+		(2, 'reply', 1),
+		(3, 'another reply', 1),
+		(4, 'nested', 2),
+		(5, 'deeper', 4),
+		(6, 'second', NULL);
+	CREATE TABLE [profiles] (
+		id INTEGER PRIMARY KEY,
+		author_id INTEGER REFERENCES authors(id),
+		settings TEXT
+	);
+	INSERT INTO [profiles] (id, author_id, settings) VALUES
+		(1, 1, '{"theme": "dark", "notifications": {"email": true}, "tags": ["elves", "rings"], "age": 81}'),
+		(2, 2, '{"theme": "light", "notifications": {"email": false}, "tags": ["sand"], "age": 65}'),
+		(3, 4, '{"theme": "dark", "notifications": {"email": false}, "tags": [], "age": 66}');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dao.InvalidateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// saving the schema closes the primary client so reconnect with the new schema
+	dao, err = ConnPrimary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dao
+}
+
+func selectRows(t *testing.T, dao Database, table, query string) ([]map[string]any, Page) {
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, page, err := dao.SelectRows(table, params, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []map[string]any
+	err = json.Unmarshal(data, &rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rows, page
+}
+
+func TestSelectRowsLimit(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	rows, page := selectRows(t, dao, "authors", "select=id,name&order=id:asc&limit=2&offset=1")
+
+	if len(rows) != 2 || rows[0]["id"] != float64(2) || rows[1]["id"] != float64(3) {
+		t.Errorf("expected authors 2 and 3 but got %v", rows)
+	}
+
+	if page.Rows != 2 || !page.Partial() || page.ContentRange() != "1-2/*" {
+		t.Errorf("unexpected page %+v with range %s", page, page.ContentRange())
+	}
+
+	rows, page = selectRows(t, dao, "authors", "select=id,books(title)")
+
+	if len(rows) != 5 || page.Partial() || page.ContentRange() != "0-4/*" {
+		t.Errorf("expected all 5 authors in one page but got %d rows and range %s", len(rows), page.ContentRange())
+	}
+
+	rows, page = selectRows(t, dao, "authors", "offset=10")
+
+	if len(rows) != 0 || page.ContentRange() != "*/*" {
+		t.Errorf("expected no rows past the end but got %v", rows)
+	}
+
+	_, _, err := dao.SelectRows("authors", url.Values{"limit": {"-1"}}, "")
+	if err == nil {
+		t.Error("expected a negative limit to be rejected")
+	}
+}
+
+func TestSelectRowsCursor(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	var names []any
+	query := "select=name&order=country:desc&limit=2"
+
+	for i := 0; i < 5; i++ {
+		rows, page := selectRows(t, dao, "authors", query)
+		for _, row := range rows {
+			names = append(names, row["name"])
+		}
+
+		if page.Cursor == "" {
+			break
+		}
+
+		query = "select=name&order=country:desc&limit=2&cursor=" + page.Cursor
+	}
+
+	expected := []any{"herbert", "le guin", "tolkien", "pratchett", "banks"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, names)
+	}
+
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %v but got %v", expected, names)
+		}
+	}
+
+	_, _, err := dao.SelectRows("authors", url.Values{"order": {"name:asc"}, "limit": {"2"}, "cursor": {"bm90IGEgY3Vyc29y"}}, "")
+
+	var paramErr ParamError
+	if !errors.As(err, &paramErr) || paramErr.Param != "cursor" {
+		t.Errorf("expected an invalid cursor to be rejected but got %v", err)
+	}
+
+	_, page := selectRows(t, dao, "authors", "select=name&order=country:desc&limit=2")
+
+	_, _, err = dao.SelectRows("authors", url.Values{"order": {"name:asc"}, "limit": {"2"}, "cursor": {page.Cursor}}, "")
+	if !errors.As(err, &paramErr) || paramErr.Param != "cursor" {
+		t.Errorf("expected a cursor from another order to be rejected but got %v", err)
+	}
+
+	_, _, err = dao.SelectRows("authors", url.Values{"select": {"count()"}, "cursor": {page.Cursor}}, "")
+	if !errors.As(err, &paramErr) || paramErr.Param != "cursor" {
+		t.Errorf("expected a cursor on an aggregate to be rejected but got %v", err)
+	}
+}
+
+func TestSelectRowsCursorNulls(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	_, err := dao.Client.Exec("UPDATE [authors] SET [country] = NULL WHERE [id] IN (2, 3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, order := range []string{"country.asc", "country.desc", "country.asc,name.desc", "country.desc,name.asc"} {
+		expected, _ := selectRows(t, dao, "authors", "select=name&order="+order+",id.asc")
+
+		var names []any
+		query := "select=name&order=" + order + "&limit=2"
+
+		for i := 0; i < 5; i++ {
+			rows, page := selectRows(t, dao, "authors", query)
+			for _, row := range rows {
+				names = append(names, row["name"])
+			}
+
+			if page.Cursor == "" {
+				break
+			}
+
+			query = "select=name&order=" + order + "&limit=2&cursor=" + page.Cursor
+		}
+
+		if len(names) != len(expected) {
+			t.Fatalf("expected every author ordered by %s but got %v", order, names)
+		}
+
+		for i := range expected {
+			if names[i] != expected[i]["name"] {
+				t.Fatalf("expected %v ordered by %s but got %v", expected, order, names)
+			}
+		}
+	}
+}
+
+func TestSelectRowsCount(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	params := url.Values{"country": {"eq.uk"}, "limit": {"1"}}
+
+	// tables without statistics are counted exactly until they are analyzed
+	for i, mode := range []string{"exact", "planned", "estimated", "planned"} {
+		if i == 3 {
+			_, err := dao.Client.Exec("ANALYZE [authors]")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, page, err := dao.SelectRows("authors", params, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// planned counts come from ANALYZE so they ignore the filter
+		expected := 3
+		if i == 3 {
+			expected = 5
+		}
+
+		if page.Total != expected || page.ContentRange() != fmt.Sprintf("0-0/%d", expected) || !page.Partial() {
+			t.Errorf("expected a %s count of %d but got %+v", mode, expected, page)
+		}
+	}
+
+	_, _, err := dao.SelectRows("authors", params, "guess")
+
+	var paramErr ParamError
+	if !errors.As(err, &paramErr) || paramErr.Param != "Prefer" {
+		t.Errorf("expected an unknown count to be rejected but got %v", err)
+	}
+
+	err = dao.InvalidateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dao, err = ConnPrimary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dao.Client.Close()
+
+	if dao.Schema.Tables["sqlite_stat1"] != nil {
+		t.Error("expected the statistics of ANALYZE to not be in the schema cache")
+	}
+}
+
+func TestSelectRowsAggregate(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	rows, _ := selectRows(t, dao, "authors", "select=country,count(),total:id.sum()&order=country:asc")
+
+	if len(rows) != 2 || rows[0]["country"] != "uk" || rows[0]["count"] != float64(3) || rows[0]["total"] != float64(10) {
+		t.Errorf("expected authors grouped by country but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=name,books(pages.sum(),pages.max())&id=eq.1")
+
+	books, _ := rows[0]["books"].([]any)
+	if len(books) != 1 || books[0].(map[string]any)["sum"] != float64(675) || books[0].(map[string]any)["max"] != float64(365) {
+		t.Errorf("expected the pages of each author to be summed but got %v", rows)
+	}
+
+	_, _, err := dao.SelectRows("authors", url.Values{"select": {"name,id.count(),books(title)"}}, "")
+	if err == nil {
+		t.Error("expected aggregates alongside embedded tables to be rejected")
+	}
+}
+
+func TestSelectRowsEmbedParams(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	rows, _ := selectRows(t, dao, "authors", "select=name,books(title)&books.order=pages.desc&books.limit=1&order=id.asc")
+
+	books, _ := rows[0]["books"].([]any)
+	if len(rows) != 5 || len(books) != 1 || books[0].(map[string]any)["title"] != "the silmarillion" {
+		t.Errorf("expected only the longest book of each author but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=name,books(title)&books.title=glob.the*&books.order=pages.asc&id=eq.1")
+
+	books, _ = rows[0]["books"].([]any)
+	if len(rows) != 1 || len(books) != 2 || books[0].(map[string]any)["title"] != "the hobbit" {
+		t.Errorf("expected the books of tolkien in order but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=name,books(title)&books.title=eq.dune")
+
+	if len(rows) != 5 {
+		t.Errorf("expected embedded filters to keep every author but got %v", rows)
+	}
+}
+
+func TestSelectRowsManyToOne(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	_, err := dao.Client.Exec("INSERT INTO [books] (id, title, pages) VALUES (6, 'anonymous', 10)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, _ := selectRows(t, dao, "books", "select=title,authors(name,books(id))&order=id.asc")
+
+	author, ok := rows[0]["authors"].(map[string]any)
+	if len(rows) != 6 || !ok || author["name"] != "tolkien" || len(author["books"].([]any)) != 2 {
+		t.Errorf("expected the author of the hobbit as an object but got %v", rows)
+	}
+
+	if rows[5]["authors"] != nil {
+		t.Errorf("expected a book without an author to embed null but got %v", rows[5])
+	}
+}
+
+func TestSelectRowsManyToMany(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	rows, _ := selectRows(t, dao, "books", "select=title,genres(name)&genres.order=name.asc&id=eq.5")
+
+	genres, _ := rows[0]["genres"].([]any)
+	if len(rows) != 1 || len(genres) != 2 || genres[0].(map[string]any)["name"] != "comedy" {
+		t.Errorf("expected mort to be a comedy and fantasy but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "genres", "select=name,books(title,authors(name))&order=id.asc")
+
+	books, _ := rows[0]["books"].([]any)
+	if len(rows) != 3 || len(books) != 3 {
+		t.Errorf("expected three fantasy books but got %v", rows)
+	}
+}
+
+func TestSelectRowsHints(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	rows, _ := selectRows(t, dao, "letters", "select=body,sender:authors!sender_id(name),recipient:authors!recipient_id(name)&id=eq.1")
+
+	if len(rows) != 1 || rows[0]["sender"].(map[string]any)["name"] != "tolkien" || rows[0]["recipient"].(map[string]any)["name"] != "pratchett" {
+		t.Errorf("expected a letter from tolkien to pratchett but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=name,received:letters!recipient_id(body)&received.order=id.asc&id=eq.1")
+
+	letters, _ := rows[0]["received"].([]any)
+	if len(letters) != 2 || letters[0].(map[string]any)["body"] != "hi" {
+		t.Errorf("expected tolkien to have received two letters but got %v", rows)
+	}
+
+	_, _, err := dao.SelectRows("letters", url.Values{"select": {"body,authors(name)"}}, "")
+	if err == nil || !strings.Contains(err.Error(), "letters.sender_id -> authors.id") {
+		t.Errorf("expected an ambiguous embed to list its foreign keys but got %v", err)
+	}
+
+	_, _, err = dao.SelectRows("letters", url.Values{"select": {"authors!sender_id(name),authors!recipient_id(name)"}}, "")
+	if err == nil {
+		t.Error("expected embedding a table twice without aliases to be rejected")
+	}
+}
+
+func TestSelectRowsManyEmbeds(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	rows, _ := selectRows(t, dao, "authors", "select=name,sent:letters!sender_id(body),received:letters!recipient_id(body)&received.order=id.asc&id=eq.1")
+
+	sent, _ := rows[0]["sent"].([]any)
+	received, _ := rows[0]["received"].([]any)
+	if len(rows) != 1 || len(sent) != 1 || len(received) != 2 || received[1].(map[string]any)["body"] != "hey" {
+		t.Errorf("expected tolkien to have sent one letter and received two but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=*,books(*)&id=eq.5")
+
+	books, ok := rows[0]["books"].([]any)
+	if len(rows) != 1 || !ok || len(books) != 0 || rows[0]["name"] != "banks" {
+		t.Errorf("expected banks to have an empty list of books but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=name,books(title),sent:letters!sender_id(body)&id=eq.5")
+
+	books, _ = rows[0]["books"].([]any)
+	sent, _ = rows[0]["sent"].([]any)
+	if len(rows) != 1 || books == nil || sent == nil || len(sent) != 0 {
+		t.Errorf("expected banks to have empty lists of letters but got %v", rows)
+	}
+}
+
+func TestSelectRowsKeyWithoutPk(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	_, err := dao.Client.Exec(`
+	DROP TABLE IF EXISTS [tagged];
+	DROP TABLE IF EXISTS [tags];
+	CREATE TABLE [tags] (name TEXT);
+	CREATE TABLE [tagged] (
+		id INTEGER PRIMARY KEY,
+		tag_id INTEGER REFERENCES tags
+	);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dao.InvalidateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dao, err = ConnPrimary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = dao.SelectRows("tagged", url.Values{"select": {"id,tags(name)"}}, "")
+	if err == nil {
+		t.Error("expected a foreign key to a table without a primary key to not be embedded")
+	}
+
+	_, _, err = dao.SelectRows("tags", url.Values{"select": {"name,tagged(id)"}}, "")
+	if err == nil {
+		t.Error("expected a foreign key to a table without a primary key to not be embedded")
+	}
+}
+
+func TestCompositeKeys(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	pk := dao.Schema.Pks["books_genres"]
+	if len(pk) != 2 || pk[0] != "book_id" || pk[1] != "genre_id" {
+		t.Errorf("expected the primary key of books_genres to be book_id,genre_id but got %v", pk)
+	}
+
+	rows, _ := selectRows(t, dao, "books_genres", "select=genre_id,ratings(score)&ratings.order=score.desc&book_id=eq.5&order=genre_id.asc")
+
+	comedy, _ := rows[1]["ratings"].([]any)
+	if len(rows) != 2 || len(rows[0]["ratings"].([]any)) != 1 || len(comedy) != 2 || comedy[0].(map[string]any)["score"] != float64(5) {
+		t.Errorf("expected ratings to be joined on both key columns but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "ratings", "select=score,books_genres(genre_id)&id=eq.2")
+
+	if len(rows) != 1 || rows[0]["books_genres"].(map[string]any)["genre_id"] != float64(3) {
+		t.Errorf("expected rating 2 to embed its book genre but got %v", rows)
+	}
+
+	body := io.NopCloser(strings.NewReader(`[{"book_id": 5, "genre_id": 3}, {"book_id": 3, "genre_id": 1}]`))
+
+	_, err := dao.InsertRows("books_genres", url.Values{}, body, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, _ = selectRows(t, dao, "books_genres", "select=count()")
+
+	if rows[0]["count"] != float64(7) {
+		t.Errorf("expected one new row after upserting on a composite key but got %v", rows)
+	}
+}
+
+func TestSelectRowsSelfEmbed(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	rows, _ := selectRows(t, dao, "comments", "select=body,replies:comments!parent_id(body)&replies.order=id.asc&id=eq.1")
+
+	replies, _ := rows[0]["replies"].([]any)
+	if len(rows) != 1 || len(replies) != 2 || replies[0].(map[string]any)["body"] != "reply" {
+		t.Errorf("expected the direct replies of the first comment but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "comments", "select=body,replies:comments(body)&replies.depth=3&replies.order=id.desc&order=id.asc")
+
+	first, _ := rows[0]["replies"].([]any)
+	if len(rows) != 6 || len(first) != 2 || first[1].(map[string]any)["body"] != "reply" {
+		t.Fatalf("expected the replies of the first comment but got %v", rows)
+	}
+
+	nested, _ := first[1].(map[string]any)["replies"].([]any)
+	if len(nested) != 1 || nested[0].(map[string]any)["body"] != "nested" {
+		t.Fatalf("expected the reply to have a nested reply but got %v", first)
+	}
+
+	deeper, _ := nested[0].(map[string]any)["replies"].([]any)
+	if len(deeper) != 1 || deeper[0].(map[string]any)["body"] != "deeper" || deeper[0].(map[string]any)["replies"] != nil {
+		t.Errorf("expected the tree to stop at a depth of 3 but got %v", nested)
+	}
+
+	if len(rows[1]["replies"].([]any)) != 1 || len(rows[5]["replies"].([]any)) != 0 {
+		t.Errorf("expected every comment to embed its own subtree but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "comments", "select=body,replies:comments(body)&replies.depth=2&replies.limit=1&id=eq.1")
+
+	first, _ = rows[0]["replies"].([]any)
+	if len(first) != 1 || len(first[0].(map[string]any)["replies"].([]any)) != 1 {
+		t.Errorf("expected the limit to only apply to the first level but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "comments", "select=id,replies:comments(body)&replies.depth=2&replies.body=neq.deeper&id=in.(2,4)&order=id.asc")
+
+	first, _ = rows[0]["replies"].([]any)
+	if len(rows) != 2 || len(first) != 1 || len(first[0].(map[string]any)["replies"].([]any)) != 0 || len(rows[1]["replies"].([]any)) != 0 {
+		t.Errorf("expected the filters of the replies to apply to the selected comments but got %v", rows)
+	}
+
+	_, _, err := dao.SelectRows("comments", url.Values{"select": {"body,comments(body)"}}, "")
+	if err == nil {
+		t.Error("expected a table embedded in itself without an alias to be rejected")
+	}
+
+	_, _, err = dao.SelectRows("comments", url.Values{"select": {"body,replies:comments(body)"}, "replies.depth": {"100"}}, "")
+	if err == nil {
+		t.Error("expected a depth past the limit to be rejected")
+	}
+}
+
+func TestSelectRowsFullText(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	err := dao.CreateFts("books", io.NopCloser(strings.NewReader(`{"columns": ["title"]}`)))
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		t.Skip("sqlite was built without fts5")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dao, err = ConnPrimary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dao.Client.Close()
+
+	// sqlite builds without fts5 cannot open the other tests' database while the index exists
+	defer dao.Client.Exec(dropFts("books"))
+
+	rows, _ := selectRows(t, dao, "books", "select=title&title=fts.the&order=id.asc")
+
+	if len(rows) != 3 || rows[0]["title"] != "the hobbit" {
+		t.Errorf("expected three books with the in their title but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "books", `select=title&title=fts.sil*`)
+
+	if len(rows) != 1 || rows[0]["title"] != "the silmarillion" {
+		t.Errorf("expected a prefix search to find the silmarillion but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "books", `select=title&title=fts."the hobbit" OR dune&order=id.asc`)
+
+	if len(rows) != 2 || rows[1]["title"] != "dune" {
+		t.Errorf("expected a phrase or dune to find two books but got %v", rows)
+	}
+
+	_, err = dao.InsertRows("books", url.Values{}, io.NopCloser(strings.NewReader(`{"id": 6, "title": "the colour of magic", "author_id": 4}`)), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, _ = selectRows(t, dao, "books", "select=title&title=fts.colour")
+
+	if len(rows) != 1 {
+		t.Errorf("expected new rows to be indexed but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "books", "select=title&title=fts.the&order=title.rank,id.asc")
+
+	if len(rows) != 4 || rows[0]["title"] != "the hobbit" {
+		t.Errorf("expected the shortest titles to rank first but got %v", rows)
+	}
+
+	_, _, err = dao.SelectRows("books", url.Values{"pages": {"fts.300"}}, "")
+	if err == nil {
+		t.Error("expected searching a column without a full text index to be rejected")
+	}
+
+	_, _, err = dao.SelectRows("books", url.Values{"order": {"title.rank"}}, "")
+	if err == nil {
+		t.Error("expected ordering by rank without a search to be rejected")
+	}
+}
+
+func TestSelectRowsJsonPaths(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	rows, _ := selectRows(t, dao, "profiles", "select=id,settings->theme,first:settings->tags->>0&settings->>notifications->>email=eq.true")
+
+	if len(rows) != 1 || rows[0]["theme"] != "dark" || rows[0]["first"] != "elves" {
+		t.Errorf("expected the theme and first tag of profile 1 but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "profiles", "select=id,notifications:settings->notifications&settings->>age=gt.65&order=settings->>age.desc")
+
+	notifications, ok := rows[0]["notifications"].(map[string]any)
+	if len(rows) != 2 || rows[1]["id"] != float64(3) || !ok || notifications["email"] != true {
+		t.Errorf("expected json objects from profiles older than 65 but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "profiles", "select=settings->>theme,count()&order=settings->>theme.asc")
+
+	if len(rows) != 2 || rows[0]["theme"] != "dark" || rows[0]["count"] != float64(2) {
+		t.Errorf("expected profiles grouped by theme but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=name,profiles(settings->>theme)&id=eq.2")
+
+	profiles, _ := rows[0]["profiles"].([]any)
+	if len(profiles) != 1 || profiles[0].(map[string]any)["theme"] != "light" {
+		t.Errorf("expected the theme of herbert to be embedded but got %v", rows)
+	}
+
+	_, _, err := dao.SelectRows("profiles", url.Values{"select": {"preferences->theme"}}, "")
+	if err == nil {
+		t.Error("expected a json path on a missing column to be rejected")
+	}
+}
+
+func TestSelectRowsLogic(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	rows, _ := selectRows(t, dao, "authors", "select=id&or=(country.eq.us,and(country.eq.uk,not.name.eq.banks))&order=id.asc")
+
+	if len(rows) != 4 || rows[3]["id"] != float64(4) {
+		t.Errorf("expected every author but banks but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=id&not.or=(country.eq.us,authors.id.eq.1)&order=id.asc")
+
+	if len(rows) != 2 || rows[0]["id"] != float64(4) {
+		t.Errorf("expected pratchett and banks but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=id,books(title)&books.or=(pages.gt.400,and(pages.lt.300,title.glob.m*))&order=id.asc")
+
+	books := rows[1]["books"].([]any)
+	if len(rows) != 5 || len(rows[0]["books"].([]any)) != 0 || len(books) != 1 || books[0].(map[string]any)["title"] != "dune" {
+		t.Errorf("expected dune and mort to be the only embedded books but got %v", rows)
+	}
+
+	for _, or := range []string{"(country.eq.us", "(and(country.eq.us)", "()", "(country)"} {
+		_, _, err := dao.SelectRows("authors", url.Values{"or": {or}}, "")
+		if err == nil {
+			t.Errorf("expected or=%s to be rejected", or)
+		}
+	}
+}
+
+func TestSelectRowsLists(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	cases := map[string][]float64{
+		"id=in.(1,3,5)":                          {1, 3, 5},
+		"id=not.in.(1,3,5)":                      {2, 4},
+		`name=in.("le guin",tolkien,"a,b")`:      {1, 3},
+		"id=between.(2,4)":                       {2, 3, 4},
+		"id=not.between.(2,4)":                   {1, 5},
+		"id=in.()":                               {},
+		"or=(id.in.(1,2),id.between.(4,5))":      {1, 2, 4, 5},
+		"or=(not.id.in.(1,2,3),name.eq.herbert)": {2, 4, 5},
+	}
+
+	for query, expected := range cases {
+		rows, _ := selectRows(t, dao, "authors", "select=id&order=id.asc&"+query)
+
+		if len(rows) != len(expected) {
+			t.Errorf("expected %s to select %v but got %v", query, expected, rows)
+			continue
+		}
+
+		for i := range expected {
+			if rows[i]["id"] != expected[i] {
+				t.Errorf("expected %s to select %v but got %v", query, expected, rows)
+			}
+		}
+	}
+
+	rows, _ := selectRows(t, dao, "authors", "select=id,books(title)&books.pages=between.(300,400)&books.id=not.in.(2)&id=eq.1")
+
+	books := rows[0]["books"].([]any)
+	if len(books) != 1 || books[0].(map[string]any)["title"] != "the hobbit" {
+		t.Errorf("expected the hobbit to be the only embedded book but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "profiles", "select=id&settings->>age=in.(65,66)&order=id.asc")
+
+	if len(rows) != 2 || rows[0]["id"] != float64(2) {
+		t.Errorf("expected json values to be compared as numbers but got %v", rows)
+	}
+
+	_, err := dao.DeleteRows("letters", url.Values{"id": {"in.(1,2)"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, _ = selectRows(t, dao, "letters", "select=id")
+
+	if len(rows) != 1 || rows[0]["id"] != float64(3) {
+		t.Errorf("expected letters 1 and 2 to be deleted but got %v", rows)
+	}
+}
+
+func TestSelectRowsMatching(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	_, err := dao.Client.Exec("INSERT INTO [books] (id, title, pages) VALUES (6, 'The Hobbit Annotated', NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]float64{
+		"title=ilike.THE H%25":           {1, 6},
+		"title=not.ilike.the%25":         {3, 5},
+		"title=match.^the [hs]":          {1, 2},
+		"title=imatch.^the h":            {1, 6},
+		"title=not.match.e":              {5},
+		"author_id=isdistinct.1":         {3, 4, 5, 6},
+		"author_id=not.isdistinct.null":  {6},
+		"pages=match.^3":                 {1, 2, 4},
+		"or=(title.imatch.dune,id.eq.5)": {3, 5},
+	}
+
+	for query, expected := range cases {
+		rows, _ := selectRows(t, dao, "books", "select=id&order=id.asc&"+query)
+
+		if len(rows) != len(expected) {
+			t.Errorf("expected %s to select %v but got %v", query, expected, rows)
+			continue
+		}
+
+		for i := range expected {
+			if rows[i]["id"] != expected[i] {
+				t.Errorf("expected %s to select %v but got %v", query, expected, rows)
+			}
+		}
+	}
+
+	_, _, err = dao.SelectRows("books", url.Values{"title": {"match.(the"}}, "")
+	if err == nil {
+		t.Error("expected an invalid pattern to fail")
+	}
+}
+
+func TestSelectRowsComputed(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	rows, _ := selectRows(t, dao, "books", "select=id,pages::text,half:pages/2.0,per:pages%2Bid*10,label:id::text&id=eq.1")
+
+	if len(rows) != 1 || rows[0]["pages"] != "310" || rows[0]["half"] != float64(155) || rows[0]["per"] != float64(320) || rows[0]["label"] != "1" {
+		t.Errorf("expected casts and computed columns of the hobbit but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=name,books(title,pages::real,thousands:pages/1000::integer)&id=eq.1&books.order=id.asc")
+
+	books := rows[0]["books"].([]any)
+	if len(books) != 2 || books[0].(map[string]any)["pages"] != float64(310) || books[0].(map[string]any)["thousands"] != float64(0) {
+		t.Errorf("expected computed columns in embedded books but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "books", "select=hundreds:pages/100,id.count()")
+
+	counts := map[float64]float64{}
+	for _, row := range rows {
+		counts[row["hundreds"].(float64)] = row["count"].(float64)
+	}
+
+	if len(rows) != 3 || counts[2] != 1 || counts[3] != 3 || counts[4] != 1 {
+		t.Errorf("expected books grouped by their computed column but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "profiles", "select=id,next:settings->>age%2B1&id=eq.1")
+
+	if len(rows) != 1 || rows[0]["next"] != float64(82) {
+		t.Errorf("expected a json path in a computed column but got %v", rows)
+	}
+}
+
+func TestSelectRowsDistinct(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	rows, _ := selectRows(t, dao, "authors", "select=country&distinct=true&order=country.asc")
+
+	if len(rows) != 2 || rows[0]["country"] != "uk" || rows[1]["country"] != "us" {
+		t.Errorf("expected each country once but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=country,name&distinct=country&order=country.asc")
+
+	if len(rows) != 2 || rows[0]["country"] != "uk" || rows[0]["name"] == nil {
+		t.Errorf("expected one author for each country but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "books", "select=hundreds:pages/100&distinct=true")
+
+	if len(rows) != 3 {
+		t.Errorf("expected 3 distinct hundreds of pages but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=country,books(author_id)&distinct=false&books.distinct=author_id&id=eq.1")
+
+	books := rows[0]["books"].([]any)
+	if len(books) != 1 || books[0].(map[string]any)["author_id"] != float64(1) {
+		t.Errorf("expected the books of tolkien to be deduplicated but got %v", rows)
+	}
+
+	data, page, err := dao.SelectRows("authors", url.Values{"select": {"country"}, "distinct": {"true"}}, "exact")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Total != 2 {
+		t.Errorf("expected an exact count of 2 distinct countries but got %d in %s", page.Total, data)
+	}
+}
+
+func TestSelectRowsOrder(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	_, err := dao.Client.Exec("INSERT INTO [books] (id, title, pages) VALUES (6, 'Zebra', NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]float64{
+		"order=pages.asc":                               {6, 5, 1, 2, 4, 3},
+		"order=pages.asc.nullslast":                     {5, 1, 2, 4, 3, 6},
+		"order=pages:desc:nullsfirst":                   {6, 3, 4, 2, 1, 5},
+		"order=title.asc":                               {6, 3, 5, 4, 1, 2},
+		"order=title.asc.nocase":                        {3, 5, 4, 1, 2, 6},
+		"order=title.nocase.desc":                       {6, 2, 1, 4, 5, 3},
+		"order=authors(name).asc,id.asc":                {6, 3, 4, 5, 1, 2},
+		"order=authors!author_id(name).nullslast,id":    {3, 4, 5, 1, 2, 6},
+		"order=authors(country).desc.nullslast,id.desc": {4, 3, 5, 2, 1, 6},
+	}
+
+	for query, expected := range cases {
+		rows, _ := selectRows(t, dao, "books", "select=id&"+query)
+
+		if len(rows) != len(expected) {
+			t.Errorf("expected %s to select %v but got %v", query, expected, rows)
+			continue
+		}
+
+		for i := range expected {
+			if rows[i]["id"] != expected[i] {
+				t.Errorf("expected %s to select %v but got %v", query, expected, rows)
+				break
+			}
+		}
+	}
+
+	rows, _ := selectRows(t, dao, "authors", "select=id,books(id)&id=eq.1&books.order=authors(name).asc,pages.desc")
+
+	books := rows[0]["books"].([]any)
+	if len(books) != 2 || books[0].(map[string]any)["id"] != float64(2) {
+		t.Errorf("expected embedded books ordered through their author but got %v", rows)
+	}
+
+	_, page, err := dao.SelectRows("books", url.Values{"order": {"authors(name)"}, "limit": {"2"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Cursor != "" {
+		t.Errorf("expected no cursor when ordering through an embed but got %s", page.Cursor)
+	}
+}
+
+func TestSelectRowsInner(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	cases := []struct {
+		table    string
+		query    string
+		expected []float64
+	}{
+		{"authors", "select=id,books(title)&books.pages=gt.350", []float64{1, 2, 3, 4, 5}},
+		{"authors", "select=id,books!inner(title)&books.pages=gt.350", []float64{1, 2, 3}},
+		{"books", "select=id,authors!author_id!inner(name)&authors.country=eq.us", []float64{3, 4}},
+		{"authors", "select=id,books!inner(title,genres!inner(name))&books.genres.name=eq.comedy", []float64{4}},
+		{"authors", "select=id,sent:letters!sender_id!inner(body)", []float64{1, 2, 4}},
+	}
+
+	for _, c := range cases {
+		rows, _ := selectRows(t, dao, c.table, c.query+"&order=id.asc")
+
+		if len(rows) != len(c.expected) {
+			t.Errorf("expected %s to select %v but got %v", c.query, c.expected, rows)
+			continue
+		}
+
+		for i := range c.expected {
+			if rows[i]["id"] != c.expected[i] {
+				t.Errorf("expected %s to select %v but got %v", c.query, c.expected, rows)
+				break
+			}
+		}
+	}
+
+	params := url.Values{"select": {"id,books!inner(title)"}, "books.pages": {"gt.350"}}
+	_, page, err := dao.SelectRows("authors", params, "exact")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Total != 3 {
+		t.Errorf("expected the count to leave out authors without matching books but got %d", page.Total)
+	}
+}
+
+func TestSelectRowsEmbedScalars(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	cases := []struct {
+		table    string
+		query    string
+		key      string
+		expected []any
+	}{
+		{"authors", "select=id,books(count)", "books", []any{float64(2), float64(1), float64(1), float64(1), float64(0)}},
+		{"authors", "select=id,books(count())&books.pages=gt.350", "books", []any{float64(1), float64(1), float64(1), float64(0), float64(0)}},
+		{"authors", "select=id,pages:books(pages.sum())", "pages", []any{float64(675), float64(412), float64(387), float64(243), nil}},
+		{"books", "select=id,genres(count)", "genres", []any{float64(1), float64(1), float64(1), float64(1), float64(2)}},
+		{"comments", "select=id,replies:comments!parent_id(count)", "replies", []any{float64(2), float64(1), float64(0), float64(1), float64(0), float64(0)}},
+	}
+
+	for _, c := range cases {
+		rows, _ := selectRows(t, dao, c.table, c.query+"&order=id.asc")
+
+		if len(rows) != len(c.expected) {
+			t.Errorf("expected %s to select %v but got %v", c.query, c.expected, rows)
+			continue
+		}
+
+		for i := range c.expected {
+			if rows[i][c.key] != c.expected[i] {
+				t.Errorf("expected %s to select %v but got %v", c.query, c.expected, rows)
+				break
+			}
+		}
+	}
+
+	rows, _ := selectRows(t, dao, "authors", "select=id,books(title,genres(count))&id=eq.4")
+
+	books := rows[0]["books"].([]any)
+	if len(books) != 1 || books[0].(map[string]any)["genres"] != float64(2) {
+		t.Errorf("expected mort to have 2 genres but got %v", rows)
+	}
+}
+
+func TestSelectRowsSpread(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	_, err := dao.Client.Exec("INSERT INTO [books] (id, title, pages) VALUES (6, 'anonymous', 10)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, _ := selectRows(t, dao, "books", "select=id,title,...authors(author:name,country)&order=id.asc")
+
+	if len(rows) != 6 || rows[0]["author"] != "tolkien" || rows[0]["country"] != "uk" || rows[0]["authors"] != nil {
+		t.Errorf("expected the author of each book to be spread into it but got %v", rows)
+	}
+
+	if author, ok := rows[5]["author"]; !ok || author != nil {
+		t.Errorf("expected a book without an author to have a null author but got %v", rows[5])
+	}
+
+	rows, _ = selectRows(t, dao, "profiles", "select=id,...authors(name,books(title))&id=eq.3")
+
+	books, _ := rows[0]["books"].([]any)
+	if len(rows) != 1 || rows[0]["name"] != "pratchett" || len(books) != 1 {
+		t.Errorf("expected the embedded books of a spread author to be kept but got %v", rows)
+	}
+
+	rows, _ = selectRows(t, dao, "authors", "select=id,books(id,...authors(country))&id=eq.2")
+
+	books, _ = rows[0]["books"].([]any)
+	if len(books) != 1 || books[0].(map[string]any)["country"] != "us" {
+		t.Errorf("expected an author spread into each embedded book but got %v", rows)
+	}
+
+	_, _, err = dao.SelectRows("authors", url.Values{"select": {"name,...books(title)"}}, "")
+	if err == nil {
+		t.Error("expected a one-to-many embed to not be spread")
+	}
+}
+
+func TestStreamRows(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	cases := map[string]string{
+		"csv":    "id,name,books\n1,tolkien,\"[{\"\"title\"\":\"\"the hobbit\"\"},{\"\"title\"\":\"\"the silmarillion\"\"}]\"\n2,herbert,\"[{\"\"title\"\":\"\"dune\"\"}]\"\n",
+		"tsv":    "id\tname\tbooks\n1\ttolkien\t\"[{\"\"title\"\":\"\"the hobbit\"\"},{\"\"title\"\":\"\"the silmarillion\"\"}]\"\n2\therbert\t\"[{\"\"title\"\":\"\"dune\"\"}]\"\n",
+		"json":   "[{\"id\":1,\"name\":\"tolkien\",\"books\":[{\"title\":\"the hobbit\"},{\"title\":\"the silmarillion\"}]},{\"id\":2,\"name\":\"herbert\",\"books\":[{\"title\":\"dune\"}]}]",
+		"ndjson": "{\"id\":1,\"name\":\"tolkien\",\"books\":[{\"title\":\"the hobbit\"},{\"title\":\"the silmarillion\"}]}\n{\"id\":2,\"name\":\"herbert\",\"books\":[{\"title\":\"dune\"}]}\n",
+	}
+
+	for format, expected := range cases {
+		params := url.Values{"select": {"id,name,books(title)"}, "order": {"id.asc"}, "limit": {"2"}, "books.order": {"id.asc"}}
+
+		var buf bytes.Buffer
+		err := dao.StreamRows(context.Background(), "authors", params, format, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != expected {
+			t.Errorf("expected %s to stream\n%s\nbut got\n%s", format, expected, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	err := dao.StreamRows(context.Background(), "authors", url.Values{"country": {"eq.us"}, "order": {"id.asc"}}, "csv", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "country,id,name\nus,2,herbert\nus,3,le guin\n" {
+		t.Errorf("expected every column in order but got\n%s", buf.String())
+	}
+
+	err = dao.StreamRows(context.Background(), "authors", url.Values{}, "xml", &buf)
+	if err == nil {
+		t.Error("expected rows to not be streamed as xml")
+	}
+}
+
+func TestSingleObject(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	data, page, err := dao.SelectRows("authors", url.Values{"select": {"name"}, "id": {"eq.1"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := SingleObject(data, page.Rows)
+	if err != nil || string(obj) != `{"name":"tolkien"}` {
+		t.Errorf("expected tolkien as an object but got %s and %v", obj, err)
+	}
+
+	for _, country := range []string{"eq.fr", "eq.uk"} {
+		data, page, err = dao.SelectRows("authors", url.Values{"country": {country}}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var objectErr ObjectError
+		if _, err = SingleObject(data, page.Rows); !errors.As(err, &objectErr) || objectErr.Rows != page.Rows {
+			t.Errorf("expected %s to not be a single object but got %v", country, err)
+		}
+	}
+
+	obj, err = dao.UpdateRows("authors", url.Values{"id": {"eq.2"}, "select": {"id,country"}}, io.NopCloser(strings.NewReader(`{"country": "ca"}`)), true)
+	if err != nil || string(obj) != `{"country":"ca","id":2}` {
+		t.Errorf("expected the updated author as an object but got %s and %v", obj, err)
+	}
+
+	var objectErr ObjectError
+	_, err = dao.UpdateRows("authors", url.Values{"country": {"eq.uk"}, "select": {"id"}}, io.NopCloser(strings.NewReader(`{"country": "gb"}`)), true)
+	if !errors.As(err, &objectErr) || objectErr.Rows != 3 {
+		t.Errorf("expected updating 3 authors to not be a single object but got %v", err)
+	}
+
+	_, err = dao.DeleteRows("letters", url.Values{"id": {"lt.10"}}, true)
+	if !errors.As(err, &objectErr) || objectErr.Rows != 3 {
+		t.Errorf("expected deleting 3 letters to not be a single object but got %v", err)
+	}
+
+	rows, _ := selectRows(t, dao, "authors", "select=id&country=eq.uk")
+	letters, _ := selectRows(t, dao, "letters", "select=id")
+
+	if len(rows) != 3 || len(letters) != 3 {
+		t.Errorf("expected the changes to be rolled back but got %v and %v", rows, letters)
+	}
+
+	_, err = dao.InsertRows("genres", url.Values{"select": {"name"}}, io.NopCloser(strings.NewReader(`{"id": 4, "name": "horror"}`)), false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamRowsFlushes(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	_, err := dao.Client.Exec(`
+	WITH RECURSIVE n(i) AS (SELECT 7 UNION ALL SELECT i + 1 FROM n WHERE i < 256)
+	INSERT INTO [comments] (id, body, parent_id) SELECT i, 'reply ' || i, 1 FROM n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	params := url.Values{"select": {"id,replies:comments!parent_id(id)"}, "order": {"id.asc"}}
+
+	err = dao.StreamRows(context.Background(), "comments", params, "json", rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []map[string]any
+	err = json.Unmarshal(rec.Body.Bytes(), &rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replies, _ := rows[0]["replies"].([]any)
+	if len(rows) != 256 || len(replies) != 252 || !rec.Flushed {
+		t.Errorf("expected 256 comments with the replies of the first flushed as they are read but got %d rows with %d replies", len(rows), len(replies))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = dao.StreamRows(ctx, "comments", params, "json", httptest.NewRecorder())
+	if err == nil {
+		t.Error("expected a cancelled stream to fail")
+	}
+}
@@ -0,0 +1,883 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// reads the value of a query param one rune at a time
+// so that errors can point to where in the param they are
+type scanner struct {
+	param string
+	src   []rune
+	pos   int
+}
+
+func newScanner(param, str string) *scanner {
+	return &scanner{param, []rune(str), 0}
+}
+
+func (s *scanner) done() bool {
+	return s.pos >= len(s.src)
+}
+
+func (s *scanner) peek() rune {
+	if s.done() {
+		return 0
+	}
+
+	return s.src[s.pos]
+}
+
+func (s *scanner) skipSpace() {
+	for !s.done() && (s.peek() == ' ' || s.peek() == '\t') {
+		s.pos++
+	}
+}
+
+func (s *scanner) accept(r rune) bool {
+	if s.peek() != r || s.done() {
+		return false
+	}
+
+	s.pos++
+	return true
+}
+
+// whether the param continues with str at the current position
+func (s *scanner) ahead(str string) bool {
+	return strings.HasPrefix(string(s.src[s.pos:]), str)
+}
+
+func (s *scanner) expect(r rune) error {
+	if s.accept(r) {
+		return nil
+	}
+
+	return s.errorf(s.pos, "expected %q but got %s", r, s.describe())
+}
+
+// describes the rune at the current position for errors
+func (s *scanner) describe() string {
+	if s.done() {
+		return "the end of the param"
+	}
+
+	return fmt.Sprintf("%q", s.peek())
+}
+
+func (s *scanner) errorf(offset int, format string, args ...any) error {
+	return ParamError{s.param, offset, fmt.Sprintf(format, args...)}
+}
+
+// reads until one of the stop runes that is not quoted or escaped.
+// quotes and escapes are removed from the word.
+func (s *scanner) word(stops string) (string, int, error) {
+	return s.read(stops, false)
+}
+
+// reads like word but keeps quotes and escapes for operands with their own syntax
+func (s *scanner) raw(stops string) (string, int, error) {
+	return s.read(stops, true)
+}
+
+func (s *scanner) read(stops string, raw bool) (string, int, error) {
+	start := s.pos
+	quoteStart := -1
+	word := ""
+
+	for !s.done() {
+		v := s.peek()
+
+		if v == '\\' && s.pos+1 < len(s.src) {
+			if raw {
+				word += string(v)
+			}
+			word += string(s.src[s.pos+1])
+			s.pos += 2
+			continue
+		}
+
+		if v == '"' {
+			if quoteStart == -1 {
+				quoteStart = s.pos
+			} else {
+				quoteStart = -1
+			}
+
+			if raw {
+				word += string(v)
+			}
+			s.pos++
+			continue
+		}
+
+		if quoteStart == -1 && strings.ContainsRune(stops, v) {
+			break
+		}
+
+		word += string(v)
+		s.pos++
+	}
+
+	if quoteStart != -1 {
+		return "", 0, s.errorf(quoteStart, "unterminated quote")
+	}
+
+	return word, start, nil
+}
+
+// the runes that end a name in the select and order params
+const selectStops = ",():!. \t"
+const orderStops = ",.:()! \t"
+
+// parses a select such as name,total:amount.sum(),sender:users!sender_id(name,email),...teams(name)
+// into the table it selects from with its columns and embedded tables
+func (schema SchemaCache) parseSelect(param string, table string) (Table, error) {
+	s := newScanner("select", param)
+	tbl := Table{table, "", "", false, false, nil, nil, nil, nil, nil, nil}
+
+	err := schema.parseItems(s, &tbl)
+	if err != nil {
+		return Table{}, err
+	}
+
+	if !s.done() {
+		return Table{}, s.errorf(s.pos, "unexpected %s", s.describe())
+	}
+
+	return tbl, nil
+}
+
+// parses a comma separated list of columns and embedded tables up to the end or a closing parenthesis
+func (schema SchemaCache) parseItems(s *scanner, tbl *Table) error {
+	for {
+		s.skipSpace()
+		if s.done() || s.peek() == ')' {
+			return nil
+		}
+
+		offset := s.pos
+		err := schema.parseItem(s, tbl)
+		if err != nil {
+			return err
+		}
+
+		err = schema.checkSpreadKeys(s, tbl, offset)
+		if err != nil {
+			return err
+		}
+
+		s.skipSpace()
+		if !s.accept(',') {
+			return nil
+		}
+	}
+}
+
+// spread embeds add their keys to the object of their parent so none of them can be another key
+// of the parent. it is checked after each item so that the error points to the item that repeats one.
+func (schema SchemaCache) checkSpreadKeys(s *scanner, tbl *Table, offset int) error {
+	spread := make(map[string]string)
+	for _, join := range tbl.joins {
+		if join.spread {
+			for _, key := range schema.outputKeys(*join) {
+				spread[key] = join.name
+			}
+		}
+	}
+
+	if len(spread) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range schema.outputKeys(*tbl) {
+		if seen[key] && spread[key] != "" {
+			return s.errorf(offset, "%s is spread into %s which then has the key %s twice. Give one of them an alias such as %s_%s:%s", spread[key], tbl.name, key, spread[key], key, key)
+		}
+
+		seen[key] = true
+	}
+
+	return nil
+}
+
+func (schema SchemaCache) parseItem(s *scanner, tbl *Table) error {
+	// many-to-one embeds can be spread into their parent such as ...users(name,email)
+	spreadOffset := s.pos
+	spread := s.ahead("...")
+	if spread {
+		s.pos += 3
+	}
+
+	name, offset, err := s.operand()
+	if err != nil {
+		return err
+	}
+
+	alias := ""
+	if !s.ahead("::") && s.accept(':') {
+		// aliases name columns and subqueries in the query so they are kept to plain names
+		if !isName(name) {
+			return s.errorf(offset, "the alias %s can only have letters, digits and underscores", name)
+		}
+
+		alias = name
+		s.skipSpace()
+
+		name, offset, err = s.operand()
+		if err != nil {
+			return err
+		}
+	}
+
+	if name == "" {
+		return s.errorf(s.pos, "expected a column or table but got %s", s.describe())
+	}
+
+	if spread && alias != "" {
+		return s.errorf(spreadOffset, "%s is spread into %s so it cannot have an alias", name, tbl.name)
+	}
+
+	// an embed can have a hint and be marked as inner such as users!sender_id!inner(name)
+	hint := ""
+	inner := false
+	for s.accept('!') {
+		mod, modOffset, err := s.word(selectStops)
+		if err != nil {
+			return err
+		}
+
+		if mod == "" {
+			return s.errorf(s.pos, "expected a hint or inner after ! but got %s", s.describe())
+		}
+
+		if mod == "inner" && inner {
+			return s.errorf(modOffset, "%s is already inner", name)
+		} else if mod == "inner" {
+			inner = true
+		} else if hint != "" {
+			return s.errorf(modOffset, "%s already has the hint %s", name, hint)
+		} else {
+			hint = mod
+		}
+	}
+
+	if spread && s.peek() != '(' {
+		return s.errorf(spreadOffset, "only embedded tables can be spread but %s is not followed by a list of columns", name)
+	}
+
+	// aggregates such as amount.sum()
+	if s.accept('.') {
+		fn, fnOffset, err := s.word(selectStops)
+		if err != nil {
+			return err
+		}
+
+		if mapAggregate(fn) == "" {
+			return s.errorf(fnOffset, "unknown aggregate function %s", fn)
+		}
+
+		return schema.parseAggregate(s, tbl, name, alias, fn, offset)
+	}
+
+	if s.peek() == '(' {
+		if name == "count" && hint == "" && !inner && !spread && schema.Tables[name] == nil {
+			return schema.parseAggregate(s, tbl, "", alias, name, offset)
+		}
+
+		return schema.parseEmbed(s, tbl, name, alias, hint, inner, spread, offset)
+	}
+
+	if hint != "" || inner {
+		return s.errorf(offset, "only embedded tables can have a hint or be inner but %s is not followed by a list of columns", name)
+	}
+
+	if s.ahead("::") || strings.ContainsRune(arithmeticOps, s.peek()) {
+		return schema.parseComputed(s, tbl, name, alias, offset)
+	}
+
+	// embeds can count their rows with a bare count such as comments(count)
+	if name == "count" && tbl.parent != nil && schema.Tables[tbl.name][name] == "" {
+		col, err := schema.newColumn(tbl.name, "", alias, name)
+		if err != nil {
+			return s.errorf(offset, "%s", err)
+		}
+
+		tbl.columns = append(tbl.columns, col)
+		return nil
+	}
+
+	col, err := schema.newColumn(tbl.name, name, alias, "")
+	if err != nil {
+		return s.errorf(offset, "%s", err)
+	}
+
+	tbl.columns = append(tbl.columns, col)
+
+	return nil
+}
+
+// whether a string is a plain name of letters, digits and underscores
+func isName(str string) bool {
+	if str == "" {
+		return false
+	}
+
+	for _, r := range str {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reads a column, json path or number that can be an operand of a computed column.
+// json paths such as settings->>age are read whole even though - is an operator.
+func (s *scanner) operand() (string, int, error) {
+	start := s.pos
+	if s.accept('*') {
+		return "*", start, nil
+	}
+
+	operand := ""
+	for {
+		word, _, err := s.word(selectStops + arithmeticOps)
+		if err != nil {
+			return "", 0, err
+		}
+
+		operand += word
+
+		if s.ahead("->") {
+			operand += "->"
+			s.pos += 2
+			if s.accept('>') {
+				operand += ">"
+			}
+
+			continue
+		}
+
+		// the fraction of a number such as 1.5
+		if _, err := strconv.Atoi(operand); err == nil && s.peek() == '.' {
+			s.pos++
+			operand += "."
+			continue
+		}
+
+		return operand, start, nil
+	}
+}
+
+// parses a cast such as price::text or a computed column such as total:amount*quantity::real.
+// operands are columns of the table or numbers and a cast applies to the whole expression.
+func (schema SchemaCache) parseComputed(s *scanner, tbl *Table, name, alias string, offset int) error {
+	col := column{"", alias, "", "", nil}
+	operand, operandOffset := name, offset
+
+	for {
+		if _, err := schema.newColumn(tbl.name, operand, "", ""); err != nil && parseNumber(operand) == nil {
+			return s.errorf(operandOffset, "%s is not a column of %s or a number", operand, tbl.name)
+		}
+
+		col.expr = append(col.expr, operand)
+
+		op := s.peek()
+		if !strings.ContainsRune(arithmeticOps, op) || s.done() {
+			break
+		}
+
+		s.pos++
+		col.expr = append(col.expr, string(op))
+
+		var err error
+		operand, operandOffset, err = s.operand()
+		if err != nil {
+			return err
+		}
+
+		if operand == "" || operand == "*" {
+			return s.errorf(operandOffset, "expected a column or number after %q but got %s", op, s.describe())
+		}
+	}
+
+	if s.accept(':') {
+		err := s.expect(':')
+		if err != nil {
+			return err
+		}
+
+		cast, castOffset, err := s.word(selectStops)
+		if err != nil {
+			return err
+		}
+
+		if mapCast(cast) == "" {
+			return s.errorf(castOffset, "cannot cast to %q. casts can be to text, integer, int, real, numeric or blob", cast)
+		}
+
+		col.cast = cast
+	}
+
+	// a cast of a single column keeps the name of the column
+	if len(col.expr) == 1 && schema.Tables[tbl.name][basePath(col.expr[0])] != "" {
+		col.name, col.expr = col.expr[0], nil
+	} else if alias == "" {
+		return s.errorf(offset, "computed columns need an alias such as total:%s", strings.Join(col.expr, ""))
+	}
+
+	tbl.columns = append(tbl.columns, col)
+
+	return nil
+}
+
+func (schema SchemaCache) parseAggregate(s *scanner, tbl *Table, name, alias, fn string, offset int) error {
+	err := s.expect('(')
+	if err != nil {
+		return err
+	}
+
+	if !s.accept(')') {
+		return s.errorf(s.pos, "aggregate function %s does not take arguments", fn)
+	}
+
+	col, err := schema.newColumn(tbl.name, name, alias, fn)
+	if err != nil {
+		return s.errorf(offset, "%s", err)
+	}
+
+	tbl.columns = append(tbl.columns, col)
+
+	return nil
+}
+
+func (schema SchemaCache) parseEmbed(s *scanner, parent *Table, name, alias, hint string, inner, spread bool, offset int) error {
+	if schema.Tables[name] == nil {
+		return s.errorf(offset, "%s", InvalidTblErr(name))
+	}
+
+	tbl := &Table{name, alias, hint, inner, spread, nil, nil, parent, nil, nil, nil}
+
+	if tbl.key() == parent.name {
+		return s.errorf(offset, "%s is embedded in itself so it needs an alias such as replies:%s", name, name)
+	}
+
+	if parent.join(tbl.key()) != nil {
+		return s.errorf(offset, "%s is embedded more than once in %s. Give each embed its own alias such as alias:%s", tbl.key(), parent.name, name)
+	}
+
+	parent.joins = append(parent.joins, tbl)
+
+	s.pos++
+
+	err := schema.parseItems(s, tbl)
+	if err != nil {
+		return err
+	}
+
+	return s.expect(')')
+}
+
+// a parsed filter such as not.in.(1,2,3)
+type filter struct {
+	negated bool
+	op      string
+	values  []string
+}
+
+// parses the value of a filter param such as age=lt.18
+func parseFilter(param, val string) (filter, error) {
+	s := newScanner(param, val)
+
+	f, err := scanFilter(s, "")
+	if err != nil {
+		return filter{}, err
+	}
+
+	if !s.done() {
+		return filter{}, s.errorf(s.pos, "unexpected %s after the value", s.describe())
+	}
+
+	return f, nil
+}
+
+// reads a filter up to one of the stop runes. each operator is checked for the operands it takes:
+// in takes a list, between takes a list of two values, is takes null, true or false,
+// fts takes a search and every other operator takes a single value.
+func scanFilter(s *scanner, stops string) (filter, error) {
+	f := filter{}
+
+	op, offset, err := s.word("." + stops)
+	if err != nil {
+		return filter{}, err
+	}
+
+	if op == "not" && s.peek() == '.' {
+		s.pos++
+		f.negated = true
+
+		op, offset, err = s.word("." + stops)
+		if err != nil {
+			return filter{}, err
+		}
+	}
+
+	if op == "" {
+		return filter{}, s.errorf(s.pos, "expected an operator but got %s", s.describe())
+	}
+
+	if op != "in" && op != "between" && op != "is" && op != "fts" && mapOperator(op) == "" {
+		return filter{}, s.errorf(offset, "unknown operator %s", op)
+	}
+
+	f.op = op
+
+	if !s.accept('.') {
+		return filter{}, s.errorf(s.pos, "expected . and a value after %s but got %s", op, s.describe())
+	}
+
+	switch op {
+	case "in", "between":
+		listOffset := s.pos
+
+		f.values, err = scanList(s)
+		if err != nil {
+			return filter{}, err
+		}
+
+		if op == "between" && len(f.values) != 2 {
+			return filter{}, s.errorf(listOffset, "between takes two values but got %d", len(f.values))
+		}
+	case "is":
+		val, valOffset, err := s.word(stops)
+		if err != nil {
+			return filter{}, err
+		}
+
+		val = strings.ToLower(val)
+		if val != "null" && val != "true" && val != "false" {
+			return filter{}, s.errorf(valOffset, "is takes null, true or false but got %s", val)
+		}
+
+		f.values = []string{val}
+	case "fts":
+		// searches have their own quoting so they are kept as they are
+		val, _, err := s.raw(stops)
+		if err != nil {
+			return filter{}, err
+		}
+
+		f.values = []string{val}
+	default:
+		val, _, err := s.word(stops)
+		if err != nil {
+			return filter{}, err
+		}
+
+		f.values = []string{val}
+	}
+
+	return f, nil
+}
+
+// reads a list of values such as (a,b,"c,d")
+func scanList(s *scanner) ([]string, error) {
+	if !s.accept('(') {
+		return nil, s.errorf(s.pos, "expected a list such as (a,b) but got %s", s.describe())
+	}
+
+	var values []string
+
+	if s.accept(')') {
+		return values, nil
+	}
+
+	for {
+		val, _, err := s.word(",)")
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, val)
+
+		if s.accept(')') {
+			return values, nil
+		}
+
+		if !s.accept(',') {
+			return nil, s.errorf(s.pos, "expected , or ) but got %s", s.describe())
+		}
+	}
+}
+
+// a tree of filters such as or=(age.lt.18,and(status.eq.active,not.role.eq.guest)).
+// groups join their conditions with and or or and the rest are single filters.
+type condition struct {
+	negated bool
+	// and or or for groups
+	logic      string
+	conditions []condition
+	// the column of a single filter
+	column string
+	filter filter
+}
+
+// parses the value of a logic param such as or=(a.eq.1,b.eq.2) into a tree of conditions
+func (schema SchemaCache) parseLogic(table, param, val string, negated bool) (condition, error) {
+	s := newScanner(param, val)
+
+	cond, err := schema.scanGroup(s, table, strings.TrimPrefix(param, "not."), negated)
+	if err != nil {
+		return condition{}, err
+	}
+
+	if !s.done() {
+		return condition{}, s.errorf(s.pos, "unexpected %s after the closing parenthesis", s.describe())
+	}
+
+	return cond, nil
+}
+
+func (schema SchemaCache) scanGroup(s *scanner, table, logic string, negated bool) (condition, error) {
+	if !s.accept('(') {
+		return condition{}, s.errorf(s.pos, "%s filters must be wrapped in parentheses", logic)
+	}
+
+	cond := condition{negated, logic, nil, "", filter{}}
+
+	for {
+		child, err := schema.scanCondition(s, table)
+		if err != nil {
+			return condition{}, err
+		}
+
+		cond.conditions = append(cond.conditions, child)
+
+		if s.accept(')') {
+			return cond, nil
+		}
+
+		if !s.accept(',') {
+			return condition{}, s.errorf(s.pos, "expected , or ) but got %s", s.describe())
+		}
+	}
+}
+
+func (schema SchemaCache) scanCondition(s *scanner, table string) (condition, error) {
+	name, offset, err := s.word(".:,()")
+	if err != nil {
+		return condition{}, err
+	}
+
+	negated := false
+	if name == "not" && s.peek() == '.' {
+		s.pos++
+		negated = true
+
+		name, offset, err = s.word(".:,()")
+		if err != nil {
+			return condition{}, err
+		}
+	}
+
+	if (name == "and" || name == "or") && s.peek() == '(' {
+		return schema.scanGroup(s, table, name, negated)
+	}
+
+	if name == "" {
+		return condition{}, s.errorf(s.pos, "expected a filter but got %s", s.describe())
+	}
+
+	if !s.accept('.') && !s.accept(':') {
+		return condition{}, s.errorf(s.pos, "expected an operator after %s but got %s", name, s.describe())
+	}
+
+	// columns can be qualified with their table like users.age.lt.18
+	tbl := table
+	if schema.Tables[table][basePath(name)] == "" && schema.Tables[name] != nil {
+		tbl = name
+
+		name, offset, err = s.word(".:,()")
+		if err != nil {
+			return condition{}, err
+		}
+
+		if !s.accept('.') && !s.accept(':') {
+			return condition{}, s.errorf(s.pos, "expected an operator after %s but got %s", name, s.describe())
+		}
+	}
+
+	if schema.Tables[tbl][basePath(name)] == "" {
+		return condition{}, s.errorf(offset, "%s", InvalidColErr(basePath(name), tbl))
+	}
+
+	err = checkPath(name)
+	if err != nil {
+		return condition{}, s.errorf(offset, "%s", err)
+	}
+
+	if tbl != table {
+		return condition{}, s.errorf(offset, "cannot filter on %s.%s because %s is not embedded in the select", tbl, name, tbl)
+	}
+
+	f, err := scanFilter(s, ",)")
+	if err != nil {
+		return condition{}, err
+	}
+
+	return condition{negated, "", nil, name, f}, nil
+}
+
+// parses an order such as created_at.desc.nullslast,name.asc.nocase or the older created_at:desc form.
+// columns can be qualified with their table like cars.make.asc, columns of a many-to-one
+// embed are written like authors(name).asc or authors!author_id(name) and columns with
+// a full text search can be ordered by their rank like description.rank
+func (schema SchemaCache) parseOrder(table, param string) ([]Param, error) {
+	var orderBy []Param
+	s := newScanner("order", param)
+
+	for {
+		s.skipSpace()
+		if s.done() {
+			return orderBy, nil
+		}
+
+		name, offset, err := s.word(orderStops)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "" {
+			return nil, s.errorf(s.pos, "expected a column but got %s", s.describe())
+		}
+
+		key := Param{table, name, nil, "", nil}
+
+		if s.peek() == '(' || s.peek() == '!' {
+			key, offset, err = schema.parseOrderEmbed(s, table, name, offset)
+			if err != nil {
+				return nil, err
+			}
+		} else if s.peek() == '.' && schema.Tables[table][basePath(name)] == "" && schema.Tables[name] != nil {
+			s.pos++
+
+			// only the table itself can qualify a column. the columns of other tables
+			// are ordered by through their embed which says which row they come from
+			if name != table {
+				col, _, err := s.word(orderStops)
+				if err != nil {
+					return nil, err
+				}
+
+				return nil, s.errorf(offset, "%s cannot be ordered by %s.%s. Use %s(%s) to order by a column of a table each row has one of", table, name, col, name, col)
+			}
+
+			key.column, offset, err = s.word(orderStops)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if schema.Tables[key.table][basePath(key.column)] == "" {
+			return nil, s.errorf(offset, "%s", InvalidColErr(basePath(key.column), key.table))
+		}
+
+		err = checkPath(key.column)
+		if err != nil {
+			return nil, s.errorf(offset, "%s", err)
+		}
+
+		for s.accept('.') || s.accept(':') {
+			op, opOffset, err := s.word(orderStops)
+			if err != nil {
+				return nil, err
+			}
+
+			err = checkOrderOp(key, op)
+			if err != nil {
+				return nil, s.errorf(opOffset, "%s", err)
+			}
+
+			key.ops = append(key.ops, op)
+		}
+
+		orderBy = append(orderBy, key)
+
+		s.skipSpace()
+		if !s.done() && !s.accept(',') {
+			return nil, s.errorf(s.pos, "expected , but got %s", s.describe())
+		}
+	}
+}
+
+// parses the column of a many-to-one embed that a table is ordered by such as authors(name).
+// the offset of the column is returned so that it can be checked like other keys.
+func (schema SchemaCache) parseOrderEmbed(s *scanner, table, name string, offset int) (Param, int, error) {
+	hint := ""
+	if s.accept('!') {
+		var err error
+		hint, _, err = s.word(orderStops)
+		if err != nil {
+			return Param{}, 0, err
+		}
+	}
+
+	if schema.Tables[name] == nil {
+		return Param{}, 0, s.errorf(offset, "%s", InvalidTblErr(name))
+	}
+
+	rel, err := schema.findRelation(table, name, hint)
+	if err != nil {
+		return Param{}, 0, s.errorf(offset, "%s", err)
+	}
+
+	if !rel.toOne {
+		return Param{}, 0, s.errorf(offset, "%s can only be ordered by %s if each row has at most one", table, name)
+	}
+
+	err = s.expect('(')
+	if err != nil {
+		return Param{}, 0, err
+	}
+
+	col, colOffset, err := s.word(orderStops)
+	if err != nil {
+		return Param{}, 0, err
+	}
+
+	err = s.expect(')')
+	if err != nil {
+		return Param{}, 0, err
+	}
+
+	return Param{name, col, nil, table, &rel}, colOffset, nil
+}
+
+// checks that an order modifier can follow the ones a key already has. keys can be
+// ranked first and then be given one direction, one collation and one nulls order.
+func checkOrderOp(key Param, op string) error {
+	kind := mapOrderOp(op)
+
+	if kind == "" {
+		return fmt.Errorf("unknown order %s. Use asc, desc, nullsfirst, nullslast, nocase, binary, rtrim or rank", op)
+	}
+
+	if kind == "rank" && key.ops != nil {
+		return errors.New("rank must come before the other modifiers of an order")
+	}
+
+	if kind == "rank" && key.embed != nil {
+		return fmt.Errorf("%s cannot be ordered by rank through an embed", key.column)
+	}
+
+	if orderOp(key, kind) != "" {
+		return fmt.Errorf("%s already has a %s", key.column, kind)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	valid := map[string]filter{
+		"eq.5":                  {false, "eq", []string{"5"}},
+		"eq.1.5":                {false, "eq", []string{"1.5"}},
+		`eq."a,b"`:              {false, "eq", []string{"a,b"}},
+		"not.is.NULL":           {true, "is", []string{"null"}},
+		`in.(a,b,"c,d")`:        {false, "in", []string{"a", "b", "c,d"}},
+		"between.(1,10)":        {false, "between", []string{"1", "10"}},
+		`fts."the hobbit" mort`: {false, "fts", []string{`"the hobbit" mort`}},
+	}
+
+	for val, expected := range valid {
+		f, err := parseFilter("col", val)
+		if err != nil {
+			t.Errorf("expected %s to parse but got %v", val, err)
+			continue
+		}
+
+		if f.negated != expected.negated || f.op != expected.op || len(f.values) != len(expected.values) {
+			t.Errorf("expected %s to parse to %v but got %v", val, expected, f)
+			continue
+		}
+
+		for i := range f.values {
+			if f.values[i] != expected.values[i] {
+				t.Errorf("expected %s to parse to %v but got %v", val, expected, f)
+			}
+		}
+	}
+
+	invalid := map[string]int{
+		"eqq.5":           0,
+		"eq":              2,
+		"in.a,b":          3,
+		"in.(a,b":         7,
+		"between.(1)":     8,
+		"between.(1,2,3)": 8,
+		"is.nil":          3,
+		"not.":            4,
+		`eq."a`:           3,
+	}
+
+	for val, offset := range invalid {
+		_, err := parseFilter("col", val)
+
+		var paramErr ParamError
+		if !errors.As(err, &paramErr) || paramErr.Param != "col" || paramErr.Offset != offset {
+			t.Errorf("expected %s to fail at offset %d but got %v", val, offset, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	dao := testDao(t)
+	defer dao.Client.Close()
+
+	cases := []struct {
+		params url.Values
+		param  string
+		offset int
+	}{
+		{url.Values{"select": {"name,books(title"}}, "select", 16},
+		{url.Values{"select": {"name,novels(title)"}}, "select", 5},
+		{url.Values{"select": {"name,age"}}, "select", 5},
+		{url.Values{"select": {"name,id.median()"}}, "select", 8},
+		{url.Values{"select": {"name,id.sum(id)"}}, "select", 12},
+		{url.Values{"select": {"name)"}}, "select", 4},
+		{url.Values{"select": {"name!inner"}}, "select", 0},
+		{url.Values{"select": {"id,...name"}}, "select", 3},
+		{url.Values{"select": {"id,...x:books(title)"}}, "select", 3},
+		{url.Values{"select": {`name,"a', (SELECT 42), 'b":books(title)`}}, "select", 5},
+		{url.Values{"select": {`"a]":name`}}, "select", 0},
+		{url.Values{"select": {`id,"x y":id.count()`}}, "select", 3},
+		{url.Values{"select": {`books("t'":title)`}}, "select", 6},
+		{url.Values{"select": {"books(id,...authors(id))"}}, "select", 9},
+		{url.Values{"select": {"books(...authors(id),id)"}}, "select", 21},
+		{url.Values{"select": {"books(*,...authors(*))"}}, "select", 8},
+		{url.Values{"select": {"books!inner!inner(title)"}}, "select", 12},
+		{url.Values{"select": {"letters!sender_id!recipient_id(body)"}}, "select", 18},
+		{url.Values{"select": {"id::date"}}, "select", 4},
+		{url.Values{"select": {"id*2"}}, "select", 0},
+		{url.Values{"select": {"double:id*age"}}, "select", 10},
+		{url.Values{"select": {"double:id*"}}, "select", 10},
+		{url.Values{"select": {"name"}, "distinct": {"country,age"}}, "distinct", 8},
+		{url.Values{"select": {"name"}, "distinct": {"country,"}}, "distinct", 8},
+		{url.Values{"select": {"name,id.count()"}, "distinct": {"true"}}, "distinct", 0},
+		{url.Values{"select": {"name,books(title)"}, "books.distinct": {"genre"}}, "books.distinct", 0},
+		{url.Values{"order": {"name.sideways"}}, "order", 5},
+		{url.Values{"order": {"name.asc.desc"}}, "order", 9},
+		{url.Values{"order": {"name.nocase.binary"}}, "order", 12},
+		{url.Values{"order": {"name.nullslast.nullsfirst"}}, "order", 15},
+		{url.Values{"order": {"books(title)"}}, "order", 0},
+		{url.Values{"order": {"novels(title)"}}, "order", 0},
+		{url.Values{"order": {"id.asc,books.title.asc"}}, "order", 7},
+		{url.Values{"order": {"letters.body"}}, "order", 0},
+		{url.Values{"select": {"name,books(title)"}, "books.order": {"authors(age)"}}, "books.order", 8},
+		{url.Values{"country": {"eqq.uk"}}, "country", 0},
+		{url.Values{"age": {"eq.1"}}, "age", 0},
+		{url.Values{"select": {`name->"a', (SELECT 42), 'b"`}}, "select", 0},
+		{url.Values{"select": {`id,name->tags->`}}, "select", 3},
+		{url.Values{"name->a b": {"eq.1"}}, "name->a b", 0},
+		{url.Values{"order": {`id,name->"a]"`}}, "order", 3},
+		{url.Values{"or": {`(id.eq.1,name->"a'".eq.1)`}}, "or", 9},
+		{url.Values{"books.title": {"eq.dune"}}, "books.title", 0},
+		{url.Values{"select": {"name,books(title)"}, "books.age": {"eq.1"}}, "books.age", 0},
+		{url.Values{"or": {"(country.eq.uk,id.between.(1))"}}, "or", 26},
+		{url.Values{"or": {"(country.eq.uk,age.eq.1)"}}, "or", 15},
+		{url.Values{"select": {"name,books(title)"}, "books.limit": {"-1"}}, "books.limit", 0},
+		{url.Values{"select": {"name,books(title)"}, "books.order": {"title.up"}}, "books.order", 6},
+		{url.Values{"select": {"name,books(count)"}, "books.limit": {"1"}}, "books.limit", 0},
+		{url.Values{"select": {"name,total:books(pages.sum())"}, "total.order": {"pages.asc"}}, "total.order", 0},
+	}
+
+	for _, c := range cases {
+		_, _, err := dao.SelectRows("authors", c.params, "")
+
+		var paramErr ParamError
+		if !errors.As(err, &paramErr) || paramErr.Param != c.param || paramErr.Offset != c.offset {
+			t.Errorf("expected %v to fail in %s at offset %d but got %v", c.params, c.param, c.offset, err)
+		}
+	}
+}
@@ -8,16 +8,16 @@ import (
 	"log"
 )
 
-func schemaFks(db *sql.DB) ([]Fk, error) {
+func schemaFks(db *sql.DB, pks PkMap) ([]Fk, error) {
 
 	var fks []Fk
 
 	rows, err := db.Query(`
-		SELECT m.name as "table", p."table" as "references", p."from", p."to"
+		SELECT m.name as "table", p."table" as "references", p.id, p."from", p."to"
 		FROM sqlite_master m
-		JOIN pragma_foreign_key_list(m.name) p ON m.name != p."table"
+		JOIN pragma_foreign_key_list(m.name) p
 		WHERE m.type = 'table'
-		ORDER BY m.name;
+		ORDER BY m.name, p.id, p.seq;
 	`)
 	if err != nil {
 		return nil, err
@@ -26,26 +26,50 @@ func schemaFks(db *sql.DB) ([]Fk, error) {
 
 	for rows.Next() {
 		var from, to, references, table sql.NullString
+		var id sql.NullInt64
 
-		rows.Scan(&table, &references, &from, &to)
+		rows.Scan(&table, &references, &id, &from, &to)
 
-		fks = append(fks, Fk{table.String, references.String, from.String, to.String})
+		// each column of a composite foreign key is its own row with the same id
+		last := len(fks) - 1
+		if last == -1 || fks[last].Table != table.String || fks[last].Id != int(id.Int64) {
+			fks = append(fks, Fk{int(id.Int64), table.String, references.String, nil, nil})
+			last++
+		}
+
+		fks[last].From = append(fks[last].From, from.String)
+		if to.Valid {
+			fks[last].To = append(fks[last].To, to.String)
+		}
+	}
 
+	// foreign keys that do not name their columns reference the primary key.
+	// keys whose columns do not line up such as ones to a table without a primary key are left out
+	var valid []Fk
+	for _, fk := range fks {
+		if fk.To == nil {
+			fk.To = pks[fk.References]
+		}
+
+		if len(fk.To) == len(fk.From) {
+			valid = append(valid, fk)
+		}
 	}
 
-	return fks, err
+	return valid, rows.Err()
 }
 
-func schemaCols(db *sql.DB) (TblMap, map[string]string, error) {
+func schemaCols(db *sql.DB) (TblMap, PkMap, error) {
 
 	tblMap := make(TblMap)
-	pkMap := make(map[string]string)
+	pkMap := make(PkMap)
 
 	rows, err := db.Query(`
 		SELECT m.name, l.name as col, l.type as colType, l.pk
 		FROM sqlite_master m
 		JOIN pragma_table_info(m.name) l
-		WHERE m.type = 'table'
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite\_%' ESCAPE '\' AND m.name NOT IN (SELECT name FROM pragma_table_list WHERE type = 'shadow')
+		ORDER BY m.name, l.pk
 	`)
 	if err != nil {
 		return nil, nil, err
@@ -56,7 +80,7 @@ func schemaCols(db *sql.DB) (TblMap, map[string]string, error) {
 		var col sql.NullString
 		var colType sql.NullString
 		var name sql.NullString
-		var pk sql.NullBool
+		var pk sql.NullInt64
 
 		rows.Scan(&name, &col, &colType, &pk)
 
@@ -64,8 +88,10 @@ func schemaCols(db *sql.DB) (TblMap, map[string]string, error) {
 			tblMap[name.String] = make(map[string]string)
 		}
 		tblMap[name.String][col.String] = colType.String
-		if pk.Bool {
-			pkMap[name.String] = col.String
+
+		// pk is the position of the column in the primary key or 0 when it is not part of it
+		if pk.Int64 > 0 {
+			pkMap[name.String] = append(pkMap[name.String], col.String)
 		}
 	}
 
@@ -117,7 +143,43 @@ func loadSchema(data []byte) (SchemaCache, error) {
 	var schema SchemaCache
 
 	err := dec.Decode(&schema)
+	if err != nil {
+		return loadLegacySchema(data)
+	}
+
+	return schema, nil
 
-	return schema, err
+}
+
+// schemas that were saved before primary and foreign keys could have more than one column
+type legacySchemaCache struct {
+	Tables TblMap
+	Pks    map[string]string
+	Fks    []struct {
+		Table      string
+		References string
+		From       string
+		To         string
+	}
+}
+
+func loadLegacySchema(data []byte) (SchemaCache, error) {
+	var legacy legacySchemaCache
+
+	err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&legacy)
+	if err != nil {
+		return SchemaCache{}, err
+	}
+
+	schema := SchemaCache{legacy.Tables, make(PkMap), nil}
+
+	for tbl, pk := range legacy.Pks {
+		schema.Pks[tbl] = []string{pk}
+	}
+
+	for i, fk := range legacy.Fks {
+		schema.Fks = append(schema.Fks, Fk{i, fk.Table, fk.References, []string{fk.From}, []string{fk.To}})
+	}
 
+	return schema, nil
 }
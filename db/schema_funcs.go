@@ -2,6 +2,7 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -33,7 +34,7 @@ func (dao *Database) InvalidateSchema() error {
 	if err != nil {
 		return err
 	}
-	fks, err := schemaFks(dao.Client)
+	fks, err := schemaFks(dao.Client, pks)
 	if err != nil {
 		return err
 	}
@@ -243,7 +244,14 @@ func (dao Database) DropTable(table string) error {
 		return InvalidTblErr(table)
 	}
 
-	_, err := dao.Client.Exec("DROP TABLE " + table)
+	query := "DROP TABLE [" + table + "]; "
+
+	// the full text index of a table cannot be used without it
+	if dao.Schema.Tables[ftsTable(table)] != nil {
+		query += fmt.Sprintf("DROP TABLE [%s]; ", ftsTable(table))
+	}
+
+	_, err := dao.Client.Exec(query)
 	if err != nil {
 		return err
 	}
@@ -251,6 +259,89 @@ func (dao Database) DropTable(table string) error {
 	return dao.InvalidateSchema()
 }
 
+// creates an fts5 table that indexes the given columns of a table so that they can be
+// searched with the fts operator. triggers keep the index in sync with the table.
+// creating the index again replaces it so the indexed columns can be changed.
+func (dao Database) CreateFts(table string, body io.ReadCloser) error {
+	type ftsIndex struct {
+		Columns []string `json:"columns"`
+	}
+
+	if dao.Schema.Tables[table] == nil {
+		return InvalidTblErr(table)
+	}
+
+	var index ftsIndex
+
+	err := json.NewDecoder(body).Decode(&index)
+	if err != nil {
+		return err
+	}
+
+	if len(index.Columns) == 0 {
+		return errors.New("a full text index needs at least one column")
+	}
+
+	cols := ""
+	newCols := ""
+	oldCols := ""
+
+	for _, col := range index.Columns {
+		if dao.Schema.Tables[table][col] == "" {
+			return InvalidColErr(col, table)
+		}
+
+		cols += fmt.Sprintf("[%s], ", col)
+		newCols += fmt.Sprintf("new.[%s], ", col)
+		oldCols += fmt.Sprintf("old.[%s], ", col)
+	}
+
+	cols = cols[:len(cols)-2]
+	newCols = newCols[:len(newCols)-2]
+	oldCols = oldCols[:len(oldCols)-2]
+
+	fts := ftsTable(table)
+
+	query := dropFts(table)
+	query += fmt.Sprintf("CREATE VIRTUAL TABLE [%s] USING fts5(%s, content='%s', content_rowid='rowid'); ", fts, cols, table)
+	query += fmt.Sprintf("CREATE TRIGGER [%s_insert] AFTER INSERT ON [%s] BEGIN INSERT INTO [%s] (rowid, %s) VALUES (new.rowid, %s); END; ", fts, table, fts, cols, newCols)
+	query += fmt.Sprintf("CREATE TRIGGER [%s_delete] AFTER DELETE ON [%s] BEGIN INSERT INTO [%s] ([%s], rowid, %s) VALUES ('delete', old.rowid, %s); END; ", fts, table, fts, fts, cols, oldCols)
+	query += fmt.Sprintf("CREATE TRIGGER [%s_update] AFTER UPDATE ON [%s] BEGIN INSERT INTO [%s] ([%s], rowid, %s) VALUES ('delete', old.rowid, %s); INSERT INTO [%s] (rowid, %s) VALUES (new.rowid, %s); END; ", fts, table, fts, fts, cols, oldCols, fts, cols, newCols)
+	// indexes the rows that are already in the table
+	query += fmt.Sprintf("INSERT INTO [%s] ([%s]) VALUES ('rebuild');", fts, fts)
+
+	_, err = dao.Client.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	return dao.InvalidateSchema()
+}
+
+func (dao Database) DropFts(table string) error {
+	if dao.Schema.Tables[ftsTable(table)] == nil {
+		return fmt.Errorf("table %s does not have a full text index", table)
+	}
+
+	_, err := dao.Client.Exec(dropFts(table))
+	if err != nil {
+		return err
+	}
+
+	return dao.InvalidateSchema()
+}
+
+func dropFts(table string) string {
+	fts := ftsTable(table)
+
+	query := ""
+	for _, trigger := range []string{"insert", "delete", "update"} {
+		query += fmt.Sprintf("DROP TRIGGER IF EXISTS [%s_%s]; ", fts, trigger)
+	}
+
+	return query + fmt.Sprintf("DROP TABLE IF EXISTS [%s]; ", fts)
+}
+
 func (dao Database) EditSchema(body io.ReadCloser) error {
 	type reqBody struct {
 		Query string `json:"query"`
@@ -46,14 +46,14 @@ func TestInvalidateSchema(t *testing.T) {
 		t.Error("expected column id on table users in schema cache not found")
 	}
 
-	if dao.Schema.Pks["cars"] != "id" {
+	if len(dao.Schema.Pks["cars"]) != 1 || dao.Schema.Pks["cars"][0] != "id" {
 		t.Error("expected primary key on cars to be id")
 	}
 
 	includesFk := false
 
 	for _, fk := range dao.Schema.Fks {
-		if fk.Table == "cars" && fk.From == "user_id" && fk.References == "users" && fk.To == "id" {
+		if fk.Table == "cars" && fk.From[0] == "user_id" && fk.References == "users" && fk.To[0] == "id" {
 			includesFk = true
 		}
 	}
@@ -13,3 +13,33 @@ func InvalidColErr(colName, tblName string) error {
 func InvalidTypeErr(column, typeName string) error {
 	return fmt.Errorf("type %s is not a valid type for column %s", typeName, column)
 }
+
+// an error in the syntax of a query param such as select or a filter.
+// offset is the position of the character in the param where the error was found.
+type ParamError struct {
+	Param   string `json:"param"`
+	Offset  int    `json:"offset"`
+	Message string `json:"message"`
+}
+
+func (err ParamError) Error() string {
+	return fmt.Sprintf("invalid %s at offset %d: %s", err.Param, err.Offset, err.Message)
+}
+
+// a single object was requested but the query did not have exactly one row
+type ObjectError struct {
+	Rows int `json:"rows"`
+}
+
+func (err ObjectError) Error() string {
+	return fmt.Sprintf("a single object was requested but %d rows were found", err.Rows)
+}
+
+// the Range header of a request could not be read as a range of rows
+type RangeError struct {
+	Range string
+}
+
+func (err RangeError) Error() string {
+	return fmt.Sprintf("invalid range %s", err.Range)
+}